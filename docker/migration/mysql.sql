@@ -0,0 +1,10 @@
+ALTER TABLE atomicop
+  ADD COLUMN max_attempts   INT           NOT NULL DEFAULT 0
+, ADD COLUMN deadline       DATETIME(6)   NULL
+, ADD COLUMN class_attempts TEXT          NULL
+, ADD COLUMN retry_at       DATETIME(6)   NULL
+, ADD COLUMN owner          VARCHAR(256)  NOT NULL DEFAULT ''
+, ADD COLUMN heartbeat      DATETIME(6)   NULL
+, ADD COLUMN reason         VARCHAR(1024) NOT NULL DEFAULT ''
+, ADD COLUMN version        BIGINT        NOT NULL DEFAULT 0
+;
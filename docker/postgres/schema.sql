@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS atomicop (
+  id             VARCHAR(256)  NOT NULL
+, state          INT           NOT NULL DEFAULT 0
+, attempts       INT           NOT NULL DEFAULT 0
+, max_attempts   INT           NOT NULL DEFAULT 0
+, deadline       TIMESTAMPTZ   NULL
+, class_attempts TEXT          NULL
+, retry_at       TIMESTAMPTZ   NULL
+, owner          VARCHAR(256)  NOT NULL DEFAULT ''
+, heartbeat      TIMESTAMPTZ   NULL
+, reason         VARCHAR(1024) NOT NULL DEFAULT ''
+, version        BIGINT        NOT NULL DEFAULT 0
+, created_at     TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP
+, updated_at     TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP
+, PRIMARY KEY(id)
+);
@@ -1,8 +1,16 @@
 CREATE TABLE IF NOT EXISTS atomicop (
-  id         VARCHAR(256) NOT NULL
-, state      INT          NOT NULL DEFAULT 0
-, attempts   INT          NOT NULL DEFAULT 0
-, created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
-, updated_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6)
+  id             VARCHAR(256)  NOT NULL
+, state          INT           NOT NULL DEFAULT 0
+, attempts       INT           NOT NULL DEFAULT 0
+, max_attempts   INT           NOT NULL DEFAULT 0
+, deadline       DATETIME(6)   NULL
+, class_attempts TEXT          NULL
+, retry_at       DATETIME(6)   NULL
+, owner          VARCHAR(256)  NOT NULL DEFAULT ''
+, heartbeat      DATETIME(6)   NULL
+, reason         VARCHAR(1024) NOT NULL DEFAULT ''
+, version        BIGINT        NOT NULL DEFAULT 0
+, created_at     DATETIME(6)   NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+, updated_at     DATETIME(6)   NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6)
 , PRIMARY KEY(id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
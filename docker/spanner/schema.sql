@@ -0,0 +1,15 @@
+CREATE TABLE atomicop (
+  id             STRING(256)  NOT NULL
+, state          INT64
+, attempts       INT64
+, max_attempts   INT64
+, deadline       TIMESTAMP
+, class_attempts STRING(MAX)
+, retry_at       TIMESTAMP
+, owner          STRING(256)
+, heartbeat      TIMESTAMP
+, reason         STRING(1024)
+, version        INT64
+, created_at     TIMESTAMP    OPTIONS (allow_commit_timestamp=true)
+, updated_at     TIMESTAMP    OPTIONS (allow_commit_timestamp=true)
+) PRIMARY KEY(id)
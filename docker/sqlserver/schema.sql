@@ -0,0 +1,17 @@
+IF OBJECT_ID(N'atomicop', N'U') IS NULL
+CREATE TABLE atomicop (
+  id             NVARCHAR(256)  NOT NULL
+, state          INT            NOT NULL DEFAULT 0
+, attempts       INT            NOT NULL DEFAULT 0
+, max_attempts   INT            NOT NULL DEFAULT 0
+, deadline       DATETIME2(6)   NULL
+, class_attempts NVARCHAR(MAX)  NULL
+, retry_at       DATETIME2(6)   NULL
+, owner          NVARCHAR(256)  NOT NULL DEFAULT ''
+, heartbeat      DATETIME2(6)   NULL
+, reason         NVARCHAR(1024) NOT NULL DEFAULT ''
+, version        BIGINT         NOT NULL DEFAULT 0
+, created_at     DATETIME2(6)   NOT NULL DEFAULT SYSUTCDATETIME()
+, updated_at     DATETIME2(6)   NOT NULL DEFAULT SYSUTCDATETIME()
+, PRIMARY KEY(id)
+);
@@ -15,11 +15,29 @@ type Oncer interface {
 type Once struct {
 	Oncer
 	r SyncRepository
+
+	recoverPanic bool
+}
+
+// OnceOption is an option for Once
+type OnceOption func(o *Once)
+
+// WithOncePanicRecovery converts a panic in fn into PanicError instead of crashing.
+// The error is not retryable because the key has been stored before fn is called.
+func WithOncePanicRecovery() OnceOption {
+	return func(o *Once) {
+		o.recoverPanic = true
+	}
 }
 
 // NewOnce creates an Once instance
-func NewOnce(r SyncRepository) *Once {
-	return &Once{r: r}
+func NewOnce(r SyncRepository, opts ...OnceOption) *Once {
+	o := &Once{r: r}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
 }
 
 // SyncRepository is a repository
@@ -29,6 +47,13 @@ type SyncRepository interface {
 	Store(ctx context.Context, key string) error
 }
 
+// SyncChecker is an optional interface of SyncRepository.
+// ShardedRepository requires SyncChecker of the previous owners while migrating.
+type SyncChecker interface {
+	// Exists reports whether key is stored without storing it.
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
 type duplicateError struct {
 	raw error
 }
@@ -46,6 +71,7 @@ func (err *duplicateError) Error() string {
 // However the error has Propagate method and the method returns true,
 // Do returns error. otherwise returns nil.
 // If Store is failed or fn returned an error, Do will return error.
+// If fn panics and the panic recovery is enabled, Do returns PanicError.
 func (o *Once) Do(ctx context.Context, key string, fn func() error) error {
 	err := o.r.Store(ctx, key)
 	if err != nil {
@@ -63,6 +89,9 @@ func (o *Once) Do(ctx context.Context, key string, fn func() error) error {
 		return err
 	}
 
+	if o.recoverPanic {
+		return protect(fn, false)
+	}
 	if err := fn(); err != nil {
 		return err
 	}
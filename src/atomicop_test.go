@@ -107,3 +107,42 @@ func Test_duplicateError(t *testing.T) {
 		t.Errorf("unexpected error: %s", err)
 	}
 }
+
+func Test_OnceDo_panic(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		opts      []OnceOption
+		wantPanic bool
+	}{
+		"Panic without recovery": {
+			wantPanic: true,
+		},
+		"Recover": {
+			opts: []OnceOption{WithOncePanicRecovery()},
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			oncer := NewOnce(NewSyncMapRepository(), tc.opts...)
+			defer func() {
+				if v := recover(); (v != nil) != tc.wantPanic {
+					t.Errorf("unexpected panic: %v", v)
+				}
+			}()
+
+			err := oncer.Do(context.TODO(), "key", func() error { panic("boom") })
+			perr, ok := err.(*PanicError)
+			if !ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if perr.Value != "boom" || len(perr.Stack) == 0 {
+				t.Errorf("unexpected panic error: %v", perr)
+			}
+			if perr.CanRetry() {
+				t.Errorf("unexpected retryable: %v", perr.CanRetry())
+			}
+		})
+	}
+}
@@ -2,14 +2,27 @@ package atomicop
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 )
 
 // RetryableOncer supports retring for oncer.
 type RetryableOncer struct {
-	limit int
-	oncer Oncer
-	r     StateRepository
+	limit      int
+	oncer      Oncer
+	r          StateRepository
+	now        func() time.Time
+	classifier ErrorClassifier
+	classes    map[string]ErrorClass
+
+	recoverPanic   bool
+	panicRetryable bool
+
+	attemptTimeout    time.Duration
+	heartbeatInterval time.Duration
+	owner             string
 }
 
 // StateValue is state
@@ -24,38 +37,318 @@ const (
 	FailedState = 3
 	// RetryState indicate need to retry
 	RetryState = 4
+	// RunningState indicate an attempt is running
+	RunningState = 5
+	// CancelledState indicate cancelled
+	CancelledState = 6
 )
 
 // State is execution state
 type State struct {
-	Attempts int
-	Value    StateValue
+	Attempts int        `json:"attempts"`
+	Value    StateValue `json:"value"`
+	// Limit is the retry limit of the key. Zero means the limit of RetryableOncer.
+	Limit int `json:"limit,omitempty"`
+	// Deadline is the time after which the key is not retried. Zero means no deadline.
+	Deadline time.Time `json:"deadline"`
+	// ClassAttempts is the number of failed attempts per error class.
+	ClassAttempts map[string]int `json:"class_attempts,omitempty"`
+	// RetryAt is the time before which the key is not retried.
+	RetryAt time.Time `json:"retry_at"`
+	// Owner is the owner of the running attempt.
+	Owner string `json:"owner,omitempty"`
+	// Heartbeat is the time when the running attempt is alive at last.
+	Heartbeat time.Time `json:"heartbeat"`
+	// Reason is the reason of the cancellation.
+	Reason string `json:"reason,omitempty"`
+	// Version is the version of state.
+	// It is maintained by StateRepository which implements StateSwapper.
+	Version int64 `json:"version"`
 }
 
+// RetryableOncerOption is an option for RetryableOncer
+type RetryableOncerOption func(r *RetryableOncer)
+
 // NewRetryableOncer creates an instance for RetryableOncer
-func NewRetryableOncer(limit int, oncer Oncer, r StateRepository) *RetryableOncer {
-	return &RetryableOncer{
-		limit: limit,
-		oncer: oncer,
-		r:     r,
+func NewRetryableOncer(limit int, oncer Oncer, r StateRepository, opts ...RetryableOncerOption) *RetryableOncer {
+	o := &RetryableOncer{
+		limit:   limit,
+		oncer:   oncer,
+		r:       r,
+		now:     time.Now,
+		classes: map[string]ErrorClass{},
+		owner:   defaultOwner(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.heartbeatInterval <= 0 {
+		o.heartbeatInterval = o.attemptTimeout / 3
+	}
+
+	return o
+}
+
+func defaultOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Backoff returns the duration to wait before the next attempt.
+// attempts is the number of failed attempts in the error class.
+type Backoff func(attempts int) time.Duration
+
+// ConstantBackoff waits d for every attempts.
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff doubles the duration from base for each attempts up to max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempts int) time.Duration {
+		d := base
+		for i := 1; i < attempts && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// ErrorClass is a retry budget for a class of errors.
+type ErrorClass struct {
+	// Name is returned by ErrorClassifier.
+	Name string
+	// Limit is the number of attempts which can fail with the class, in the same unit as the limit of the key.
+	// For example, Limit 3 calls the function at most 3 times for the class.
+	// Zero means no limit except the limit of the key.
+	Limit int
+	// Backoff is used before the next attempt. Nil means no wait.
+	Backoff Backoff
+}
+
+// ErrorClassifier classifies a retryable error into a class name.
+// An empty or unknown name means the error is counted only against the limit of the key.
+type ErrorClassifier func(err error) string
+
+// WithErrorClasses makes retryable errors counted against the budget of their class.
+// If the attempts of a class exceeds its limit, the key becomes failed and Do returns nil.
+func WithErrorClasses(classifier ErrorClassifier, classes ...ErrorClass) RetryableOncerOption {
+	return func(r *RetryableOncer) {
+		r.classifier = classifier
+		for _, c := range classes {
+			r.classes[c.Name] = c
+		}
 	}
 }
 
 // StateRepository is an interface for retryable oncer
 type StateRepository interface {
 	// GetState gets state.
+	// If key is unknown, GetState returns the state whose value is InitState.
 	GetState(ctx context.Context, key string) (*State, error)
 	// UpdateState update state.
 	UpdateState(ctx context.Context, key string, state State) error
 }
 
+// StateSwapper is an optional interface of StateRepository.
+// If StateRepository implements StateSwapper, RetryableOncer updates state by compare-and-swap.
+type StateSwapper interface {
+	// CompareAndSwapState updates state only if the version of saved state equals version,
+	// and returns the new version. The version of unknown key is zero.
+	// If the version is different, CompareAndSwapState must return an error which has Conflict() bool method.
+	CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error)
+}
+
+type conflictError struct {
+	raw error
+}
+
+func (*conflictError) Conflict() bool {
+	return true
+}
+
+func (err *conflictError) Error() string {
+	return err.raw.Error()
+}
+
+func conflicted(err error) bool {
+	v, ok := err.(interface {
+		Conflict() bool
+	})
+	return ok && v.Conflict()
+}
+
+// DoOption is an option for RetryableOncer.Do
+type DoOption func(state *State)
+
+// WithLimit overrides the retry limit for the key.
+func WithLimit(limit int) DoOption {
+	return func(state *State) {
+		if state.Limit == 0 {
+			state.Limit = limit
+		}
+	}
+}
+
+// WithDeadline gives up retrying the key after t.
+func WithDeadline(t time.Time) DoOption {
+	return func(state *State) {
+		if state.Deadline.IsZero() {
+			state.Deadline = t
+		}
+	}
+}
+
+// exceeded reports whether state ran out of its retry budget.
+func (r *RetryableOncer) exceeded(state *State) bool {
+	limit := r.limit
+	if state.Limit > 0 {
+		limit = state.Limit
+	}
+	if state.Attempts > limit {
+		return true
+	}
+
+	return !state.Deadline.IsZero() && r.now().After(state.Deadline)
+}
+
+// classify counts err against its class and reports whether the class has budget for the next attempt.
+func (r *RetryableOncer) classify(state *State, err error) bool {
+	if r.classifier == nil {
+		return true
+	}
+	class, ok := r.classes[r.classifier(err)]
+	if !ok {
+		return true
+	}
+
+	counts := make(map[string]int, len(state.ClassAttempts)+1)
+	for k, v := range state.ClassAttempts {
+		counts[k] = v
+	}
+	counts[class.Name]++
+	state.ClassAttempts = counts
+
+	if class.Backoff != nil {
+		state.RetryAt = r.now().Add(class.Backoff(counts[class.Name]))
+	}
+
+	return class.Limit == 0 || counts[class.Name] < class.Limit
+}
+
+// WithPanicRecovery converts a panic in fn into PanicError instead of crashing.
+// If retryable is true, the key is retried as same as the other retryable errors.
+// Otherwise the key becomes failed and Do returns PanicError.
+func WithPanicRecovery(retryable bool) RetryableOncerOption {
+	return func(r *RetryableOncer) {
+		r.recoverPanic = true
+		r.panicRetryable = retryable
+	}
+}
+
+// WithAttemptTimeout sets the timeout of each attempt.
+// If it is set, the key is saved as running state with owner and heartbeat before fn is called,
+// and the heartbeat is renewed in background while fn is running.
+// A running key whose heartbeat is not renewed within timeout is regarded as stalled,
+// then it can be retried by another process.
+// StateRepository must implement StateSwapper not to overwrite the state of the process which takes over.
+// Otherwise Do returns an error.
+func WithAttemptTimeout(timeout time.Duration) RetryableOncerOption {
+	return func(r *RetryableOncer) {
+		r.attemptTimeout = timeout
+	}
+}
+
+// WithHeartbeatInterval sets the interval of heartbeat renewal.
+// The default is a third of the attempt timeout.
+func WithHeartbeatInterval(interval time.Duration) RetryableOncerOption {
+	return func(r *RetryableOncer) {
+		r.heartbeatInterval = interval
+	}
+}
+
+// WithOwner sets the owner which is saved with running state.
+// The default is hostname and process id.
+func WithOwner(owner string) RetryableOncerOption {
+	return func(r *RetryableOncer) {
+		r.owner = owner
+	}
+}
+
+// stalled reports whether the running attempt of state is stalled.
+func (r *RetryableOncer) stalled(state *State) bool {
+	return r.attemptTimeout > 0 && r.now().Sub(state.Heartbeat) > r.attemptTimeout
+}
+
+// update updates state unless the key has been cancelled.
+// If the key has been cancelled, update returns ErrCancelled.
+// If StateRepository implements StateSwapper, state is updated by compare-and-swap
+// and the version of state is renewed.
+func (r *RetryableOncer) update(ctx context.Context, key string, state *State) error {
+	if swapper, ok := r.r.(StateSwapper); ok {
+		version, err := swapper.CompareAndSwapState(ctx, key, state.Version, *state)
+		if conflicted(err) {
+			if current, err := r.r.GetState(ctx, key); err == nil && current.Value == CancelledState {
+				return &ErrCancelled{Reason: current.Reason}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		state.Version = version
+		return nil
+	}
+
+	// the check and the update are not atomic.
+	// it is safe because Cancel requires StateSwapper, so the key is never cancelled here.
+	current, err := r.r.GetState(ctx, key)
+	if err != nil {
+		return err
+	}
+	if current.Value == CancelledState {
+		return &ErrCancelled{Reason: current.Reason}
+	}
+
+	return r.r.UpdateState(ctx, key, *state)
+}
+
+// finish saves the result of the finished attempt.
+// If the key is cancelled while the attempt is running, the result of the attempt wins
+// and the cancellation applies only to later attempts.
+func (r *RetryableOncer) finish(ctx context.Context, key string, state *State) error {
+	err := r.update(ctx, key, state)
+	if !cancelled(err) {
+		return err
+	}
+	swapper, ok := r.r.(StateSwapper)
+	if !ok {
+		return err
+	}
+
+	current, gerr := r.r.GetState(ctx, key)
+	if gerr != nil || current.Value != CancelledState || current.Attempts != state.Attempts {
+		return err
+	}
+	version, err := swapper.CompareAndSwapState(ctx, key, current.Version, *state)
+	if err != nil {
+		return err
+	}
+	state.Version = version
+	return nil
+}
+
 // updateOnece update state at once using oncer.
-func (r *RetryableOncer) updateOnce(ctx context.Context, idempotencyKey, updateKey string, attenmpts int, state StateValue) error {
+func (r *RetryableOncer) updateOnce(ctx context.Context, idempotencyKey, updateKey string, state State) error {
 	return r.oncer.Do(ctx, idempotencyKey, func() error {
-		return r.r.UpdateState(ctx, updateKey, State{
-			Attempts: attenmpts,
-			Value:    state,
-		})
+		return r.update(ctx, updateKey, &state)
 	})
 }
 
@@ -71,15 +364,82 @@ func (err *retryableError) Error() string {
 	return err.raw.Error()
 }
 
+type retryLaterError struct {
+	at time.Time
+}
+
+func (*retryLaterError) CanRetry() bool {
+	return true
+}
+
+// RetryAt returns the time from which the key can be retried.
+func (err *retryLaterError) RetryAt() time.Time {
+	return err.at
+}
+
+func (err *retryLaterError) Error() string {
+	return fmt.Sprintf("retry after %s", err.at.Format(time.RFC3339Nano))
+}
+
+// ErrCancelled is returned by Do if the key is cancelled.
+// ErrCancelled has Cancelled() bool method.
+type ErrCancelled struct {
+	Reason string
+}
+
+// Cancelled returns true.
+func (*ErrCancelled) Cancelled() bool {
+	return true
+}
+
+func (err *ErrCancelled) Error() string {
+	return fmt.Sprintf("cancelled: %s", err.Reason)
+}
+
+type inProgressError struct {
+	owner string
+}
+
+func (*inProgressError) CanRetry() bool {
+	return true
+}
+
+func (err *inProgressError) Error() string {
+	return fmt.Sprintf("running by %s", err.owner)
+}
+
 // Do execute function at once.
 // If function had been executed and succeeded, This method do nothing.
 // If function return retryable error which has to have CanRetry() bool method,
 // it is saved to repository with state.
 // Then Do is able to be executed again.
+// If function panics, the key is saved as retry state before the panic is propagated
+// unless the panic recovery is enabled by WithPanicRecovery.
+// If the error class of the last attempt has backoff and it is not elapsed yet,
+// Do returns retryable error which has RetryAt() time.Time method without executing function.
+// If an attempt of the key is running in another process, Do returns retryable error.
+// If the key is cancelled by Cancel, Do returns ErrCancelled.
+//
+// The retry policy of the key can be overridden by opts such as WithLimit and WithDeadline.
+// The policy is saved to repository with state at the first attempt,
+// then the saved policy is used by the following attempts even if opts are different.
 //
 // Attention:
 //   `key-\d+` is reserved by system. Therefore, You can not use that key.
-func (r *RetryableOncer) Do(ctx context.Context, key string, fn func() error) error {
+func (r *RetryableOncer) Do(ctx context.Context, key string, fn func() error, opts ...DoOption) error {
+	return r.DoContext(ctx, key, func(context.Context) error {
+		return fn()
+	}, opts...)
+}
+
+// DoContext is same as Do except that fn receives the context of the attempt.
+// If the attempt timeout is set by WithAttemptTimeout, the context is cancelled after the timeout
+// and the error of the timed out attempt is regarded as retryable.
+func (r *RetryableOncer) DoContext(ctx context.Context, key string, fn func(ctx context.Context) error, opts ...DoOption) error {
+	if _, ok := r.r.(StateSwapper); !ok && r.attemptTimeout > 0 {
+		return errors.New("attempt timeout requires StateRepository which implements StateSwapper")
+	}
+
 	current, err := r.r.GetState(ctx, key)
 	if err != nil {
 		// GetState failed would be retryable
@@ -90,36 +450,158 @@ func (r *RetryableOncer) Do(ctx context.Context, key string, fn func() error) er
 		return nil
 	}
 
+	if current.Value == CancelledState {
+		return &ErrCancelled{Reason: current.Reason}
+	}
+
+	if current.Value == RetryState && r.now().Before(current.RetryAt) {
+		return &retryLaterError{current.RetryAt}
+	}
+
+	if current.Value == RunningState && !r.stalled(current) {
+		return &inProgressError{current.Owner}
+	}
+
+	for _, opt := range opts {
+		opt(current)
+	}
+
 	current.Attempts++
 	id := fmt.Sprintf("%s-%d", key, current.Attempts)
+	// the attempt key is routed with the key by ShardedRepository
+	rctx := withRoutingKey(ctx, key)
 
-	// under here, retry or init state
-	if current.Attempts > r.limit {
-		r.updateOnce(ctx, id, key, current.Attempts, FailedState)
+	// under here, retry, stalled or init state
+	if r.exceeded(current) {
+		current.Value = FailedState
+		r.updateOnce(rctx, id, key, *current)
 		return nil
 	}
 
-	return r.oncer.Do(ctx, id, func() error {
-		err := fn()
+	return r.oncer.Do(rctx, id, func() error {
+		next := *current
+		hb, err := r.run(ctx, key, &next)
+		if cancelled(err) {
+			return err
+		}
+		if err != nil {
+			return &retryableError{err}
+		}
+		err = r.call(func() error {
+			return r.attempt(ctx, fn)
+		}, func() {
+			hb.stop(&next)
+			next.Value = RetryState
+			r.update(ctx, key, &next)
+		})
+		hb.stop(&next)
+
 		if retryableErr, ok := err.(interface {
 			CanRetry() bool
 		}); ok && retryableErr.CanRetry() {
-			r.r.UpdateState(ctx, key, State{
-				Attempts: current.Attempts,
-				Value:    RetryState,
-			})
+			next.Value = RetryState
+			if !r.classify(&next, err) {
+				// the budget of the class is exhausted. the key fails as same as the limit of the key.
+				next.Value = FailedState
+				return r.finish(ctx, key, &next)
+			}
+			if err := r.update(ctx, key, &next); cancelled(err) {
+				return err
+			} else if err != nil {
+				// the retry state, such as the class attempts and the backoff, is not saved
+				return &retryableError{err}
+			}
 			return err
 		}
 		if err != nil {
-			return r.r.UpdateState(ctx, key, State{
-				Attempts: current.Attempts,
-				Value:    FailedState,
-			})
+			next.Value = FailedState
+			if err := r.finish(ctx, key, &next); err != nil {
+				return err
+			}
+			if _, ok := err.(*PanicError); ok {
+				return err
+			}
+			return nil
 		}
 
-		return r.r.UpdateState(ctx, key, State{
-			Attempts: current.Attempts,
-			Value:    DoneState,
-		})
+		next.Value = DoneState
+		return r.finish(ctx, key, &next)
 	})
 }
+
+// Cancel cancels the key.
+// The cancelled key is never executed, and Do returns ErrCancelled.
+// If an attempt of the key is running, the cancellation is applied once the attempt finishes.
+// If the running attempt finishes as done or failed, its result wins over the cancellation.
+// If the key is already done or failed, Cancel returns an error.
+// StateRepository must implement StateSwapper not to lose the cancellation by the concurrent update.
+func (r *RetryableOncer) Cancel(ctx context.Context, key, reason string) error {
+	swapper, ok := r.r.(StateSwapper)
+	if !ok {
+		return errors.New("cancel requires StateRepository which implements StateSwapper")
+	}
+
+	for {
+		current, err := r.r.GetState(ctx, key)
+		if err != nil {
+			return &retryableError{err}
+		}
+
+		switch current.Value {
+		case CancelledState:
+			return nil
+		case DoneState, FailedState:
+			return fmt.Errorf("%s is already finished", key)
+		}
+
+		current.Value = CancelledState
+		current.Reason = reason
+		// retry if the state is updated concurrently
+		if _, err := swapper.CompareAndSwapState(ctx, key, current.Version, *current); !conflicted(err) {
+			return err
+		}
+	}
+}
+
+func cancelled(err error) bool {
+	_, ok := err.(*ErrCancelled)
+	return ok
+}
+
+// attempt calls fn with the attempt timeout.
+func (r *RetryableOncer) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if r.attemptTimeout <= 0 {
+		return fn(ctx)
+	}
+
+	actx, cancel := context.WithTimeout(ctx, r.attemptTimeout)
+	defer cancel()
+
+	err := fn(actx)
+	if err != nil && actx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		if _, ok := err.(interface {
+			CanRetry() bool
+		}); !ok {
+			return &retryableError{err}
+		}
+	}
+
+	return err
+}
+
+// call calls fn with the panic recovery.
+// If the recovery is disabled, onPanic is called before the panic is propagated.
+func (r *RetryableOncer) call(fn func() error, onPanic func()) error {
+	if r.recoverPanic {
+		return protect(fn, r.panicRetryable)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			onPanic()
+			panic(v)
+		}
+	}()
+
+	return fn()
+}
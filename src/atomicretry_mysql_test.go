@@ -1,14 +1,12 @@
 package atomicop
 
 import (
-	"context"
 	"database/sql"
-	"errors"
 	"testing"
 )
 
-func prepare(t *testing.T) (*RetryableOncer, func() error) {
-	db, err := sql.Open("mysql", "root:pass@tcp(127.0.0.1:3306)/atomicop")
+func prepareMySQL(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	db, err := sql.Open("mysql", "root:pass@tcp(127.0.0.1:3306)/atomicop?parseTime=true")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -18,112 +16,38 @@ func prepare(t *testing.T) (*RetryableOncer, func() error) {
 		t.Error("failed to cleanup table")
 	}
 
-	insertSQLBuilder := func(key string) (string, []interface{}) {
-		return "INSERT INTO atomicop(id) VALUE(?)", []interface{}{key}
-	}
-	getStateSQLBuilder := func(key string) (string, []interface{}) {
-		return "SELECT state, attempts FROM atomicop WHERE id = ?", []interface{}{key}
-	}
-	stateBinder := func(rows *sql.Rows, state *State) error {
-		return rows.Scan(&state.Value, &state.Attempts)
-	}
-	updateStateSQLBuilder := func(key string, state State) (string, []interface{}) {
-		q := `INSERT INTO atomicop(id, state, attempts) VALUES(?, ?, ?) ON DUPLICATE KEY
-			  UPDATE state = VALUES(state), attempts = VALUES(attempts)`
-		args := []interface{}{key, state.Value, state.Attempts}
-		return q, args
-	}
-
-	r := NewMySQLRepository(
-		db,
-		insertSQLBuilder,
-		getStateSQLBuilder,
-		stateBinder,
-		updateStateSQLBuilder,
-	)
-	return NewRetryableOncer(5, NewOnce(r), r), db.Close
+	r := NewDefaultMySQLRepository(db, "atomicop")
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), db.Close
 }
 
 func Test_RetryableOncer_at_once_with_MySQL(t *testing.T) {
-	retryableOncer, closer := prepare(t)
-	defer closer()
-
-	counter := 0
-	for i := 0; i < 5; i++ {
-		err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
-			counter++
-			return nil
-		})
-		if err != nil {
-			t.Errorf("unexpected error: %s", err)
-		}
-	}
-
-	if counter != 1 {
-		t.Errorf("unexpected execution times: %d", counter)
-	}
+	testRetryableOncerAtOnce(t, prepareMySQL)
 }
 
 func Test_RetryableOncer_retry_success_with_MySQL(t *testing.T) {
-	retryableOncer, closer := prepare(t)
-	defer closer()
-
-	counter := 0
-	successAt := 3
-	for i := 0; i < 5; i++ {
-		err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
-			counter++
-			if counter < successAt {
-				return &retryableError{errors.New("retry")}
-			}
-			return nil
-		})
-		if err != nil {
-			if rerr, ok := err.(interface {
-				CanRetry() bool
-			}); ok && rerr.CanRetry() {
-				continue
-			}
-
-			t.Fatalf("unexpected error: %s", err)
-		}
-	}
-
-	if counter != successAt {
-		t.Errorf("unexpected execution time: %d", counter)
-	}
+	testRetryableOncerRetrySuccess(t, prepareMySQL)
 }
 
 func Test_RetryableOncer_retry_failed_with_MySQL(t *testing.T) {
-	retryableOncer, closer := prepare(t)
-	defer closer()
+	testRetryableOncerRetryFailed(t, prepareMySQL)
+}
 
-	counter := 0
-	for i := 0; i < 10; i++ {
-		retryableOncer.Do(context.TODO(), "retryableKey", func() error {
-			counter++
-			return errors.New("no retry")
-		})
-	}
+func Test_RetryableOncer_retry_limit_exceeded_with_MySQL(t *testing.T) {
+	testRetryableOncerRetryLimitExceeded(t, prepareMySQL)
+}
 
-	if counter != 1 {
-		t.Errorf("unexpected execution time: %d", counter)
-	}
+func Test_RetryableOncer_WithLimit_with_MySQL(t *testing.T) {
+	testRetryableOncerWithLimit(t, prepareMySQL)
 }
 
-func Test_RetryableOncer_retry_limit_exceeded_with_MySQL(t *testing.T) {
-	retryableOncer, closer := prepare(t)
-	defer closer()
+func Test_RetryableOncer_WithErrorClasses_with_MySQL(t *testing.T) {
+	testRetryableOncerWithErrorClasses(t, prepareMySQL)
+}
 
-	counter := 0
-	for i := 0; i < 10; i++ {
-		retryableOncer.Do(context.TODO(), "retryableKey", func() error {
-			counter++
-			return &retryableError{errors.New("retry")}
-		})
-	}
+func Test_RetryableOncer_WithAttemptTimeout_with_MySQL(t *testing.T) {
+	testRetryableOncerWithAttemptTimeout(t, prepareMySQL)
+}
 
-	if counter != 5 {
-		t.Errorf("unexpected execution time: %d", counter)
-	}
+func Test_RetryableOncer_Cancel_with_MySQL(t *testing.T) {
+	testRetryableOncerCancel(t, prepareMySQL)
 }
@@ -3,10 +3,22 @@ package atomicop
 import (
 	"context"
 	"errors"
-	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// recordingStateRepository calls fn with state before every compare-and-swap.
+type recordingStateRepository struct {
+	*MemoryStateRepository
+	fn func(state State)
+}
+
+func (r *recordingStateRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	r.fn(state)
+	return r.MemoryStateRepository.CompareAndSwapState(ctx, key, version, state)
+}
+
 type mockStateRepository struct {
 	StateRepository
 
@@ -23,21 +35,8 @@ func (r *mockStateRepository) UpdateState(ctx context.Context, key string, state
 }
 
 func Test_RetryableOncer_at_once(t *testing.T) {
-	var m sync.Map
-	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), &mockStateRepository{
-		MockGetState: func(_ context.Context, key string) (*State, error) {
-			st := &State{
-				Attempts: 0,
-				Value:    InitState,
-			}
-			act, _ := m.LoadOrStore(key, st)
-			return act.(*State), nil
-		},
-		MockUpdateState: func(_ context.Context, key string, state State) error {
-			m.Store(key, &state)
-			return nil
-		},
-	})
+	r := NewMemoryStateRepository()
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r)
 
 	counter := 0
 	for i := 0; i < 5; i++ {
@@ -81,23 +80,30 @@ func Test_RetryableOncer_GetState(t *testing.T) {
 	}
 }
 
-func Test_RetryableOncer_retry_success(t *testing.T) {
-	var m sync.Map
+func Test_RetryableOncer_UpdateState(t *testing.T) {
+	updateErr := errors.New("failed to update")
 	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), &mockStateRepository{
 		MockGetState: func(_ context.Context, key string) (*State, error) {
-			st := &State{
-				Attempts: 0,
-				Value:    InitState,
-			}
-			act, _ := m.LoadOrStore(key, st)
-			return act.(*State), nil
+			return &State{Attempts: 0, Value: InitState}, nil
 		},
 		MockUpdateState: func(_ context.Context, key string, state State) error {
-			m.Store(key, &state)
-			return nil
+			return updateErr
 		},
 	})
 
+	// the failure to save the retry state is returned instead of the error of the function
+	err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+		return &retryableError{errors.New("retry")}
+	})
+	if v, ok := err.(*retryableError); !ok || v.raw != updateErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_RetryableOncer_retry_success(t *testing.T) {
+	r := NewMemoryStateRepository()
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r)
+
 	counter := 0
 	successAt := 3
 	for i := 0; i < 5; i++ {
@@ -125,21 +131,8 @@ func Test_RetryableOncer_retry_success(t *testing.T) {
 }
 
 func Test_RetryableOncer_retry_failed(t *testing.T) {
-	var m sync.Map
-	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), &mockStateRepository{
-		MockGetState: func(_ context.Context, key string) (*State, error) {
-			st := &State{
-				Attempts: 0,
-				Value:    InitState,
-			}
-			act, _ := m.LoadOrStore(key, st)
-			return act.(*State), nil
-		},
-		MockUpdateState: func(_ context.Context, key string, state State) error {
-			m.Store(key, &state)
-			return nil
-		},
-	})
+	r := NewMemoryStateRepository()
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r)
 
 	counter := 0
 	for i := 0; i < 10; i++ {
@@ -155,22 +148,9 @@ func Test_RetryableOncer_retry_failed(t *testing.T) {
 }
 
 func Test_RetryableOncer_retry_limit_exceeded(t *testing.T) {
-	var m sync.Map
+	r := NewMemoryStateRepository()
 	retryLimit := 5
-	retryableOncer := NewRetryableOncer(retryLimit, NewOnce(NewSyncMapRepository()), &mockStateRepository{
-		MockGetState: func(_ context.Context, key string) (*State, error) {
-			st := &State{
-				Attempts: 0,
-				Value:    InitState,
-			}
-			act, _ := m.LoadOrStore(key, st)
-			return act.(*State), nil
-		},
-		MockUpdateState: func(_ context.Context, key string, state State) error {
-			m.Store(key, &state)
-			return nil
-		},
-	})
+	retryableOncer := NewRetryableOncer(retryLimit, NewOnce(NewSyncMapRepository()), r)
 
 	counter := 0
 	for i := 0; i < 10; i++ {
@@ -185,6 +165,379 @@ func Test_RetryableOncer_retry_limit_exceeded(t *testing.T) {
 	}
 }
 
+func Test_RetryableOncer_WithLimit(t *testing.T) {
+	r := NewMemoryStateRepository()
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r)
+
+	counter := 0
+	for i := 0; i < 10; i++ {
+		// the limit of the first attempt is stored and used by the following attempts.
+		limit := 2
+		if i > 0 {
+			limit = 8
+		}
+		retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			return &retryableError{errors.New("retry")}
+		}, WithLimit(limit))
+	}
+
+	if counter != 2 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+
+	st, _ := r.GetState(context.TODO(), "retryableKey")
+	if st.Value != FailedState || st.Limit != 2 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_RetryableOncer_WithDeadline(t *testing.T) {
+	r := NewMemoryStateRepository()
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r)
+	now := time.Now()
+	retryableOncer.now = func() time.Time { return now }
+	deadline := now.Add(time.Hour)
+
+	counter := 0
+	for i := 0; i < 5; i++ {
+		if i == 2 {
+			now = deadline.Add(time.Second)
+		}
+		retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			return &retryableError{errors.New("retry")}
+		}, WithDeadline(deadline))
+	}
+
+	if counter != 2 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+
+	st, _ := r.GetState(context.TODO(), "retryableKey")
+	if st.Value != FailedState || !st.Deadline.Equal(deadline) {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_RetryableOncer_WithErrorClasses(t *testing.T) {
+	r := NewMemoryStateRepository()
+	timeoutErr := &retryableError{errors.New("timeout")}
+	rateLimitErr := &retryableError{errors.New("rate limit")}
+	classifier := func(err error) string {
+		switch err {
+		case timeoutErr:
+			return "timeout"
+		case rateLimitErr:
+			return "rate limit"
+		}
+		return ""
+	}
+	retryableOncer := NewRetryableOncer(10, NewOnce(NewSyncMapRepository()), r, WithErrorClasses(classifier,
+		ErrorClass{Name: "timeout", Limit: 3},
+		ErrorClass{Name: "rate limit", Limit: 5, Backoff: ConstantBackoff(time.Minute)},
+	))
+	now := time.Now()
+	retryableOncer.now = func() time.Time { return now }
+
+	errs := []error{rateLimitErr, timeoutErr, rateLimitErr, timeoutErr, timeoutErr}
+	counter := 0
+	for i := 0; i < 10; i++ {
+		err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			err := errs[counter]
+			counter++
+			return err
+		})
+		if v, ok := err.(interface {
+			RetryAt() time.Time
+		}); ok {
+			if !v.RetryAt().Equal(now.Add(time.Minute)) {
+				t.Errorf("unexpected retry at: %s", v.RetryAt())
+			}
+			now = now.Add(time.Minute)
+		}
+	}
+
+	// the key fails at the third timeout
+	if counter != 5 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+
+	st, _ := r.GetState(context.TODO(), "retryableKey")
+	if st.Value != FailedState || st.ClassAttempts["timeout"] != 3 || st.ClassAttempts["rate limit"] != 2 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_ExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 5*time.Second)
+	tests := map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	}
+
+	for attempts, want := range tests {
+		if got := backoff(attempts); got != want {
+			t.Errorf("unexpected backoff of %d: %s", attempts, got)
+		}
+	}
+}
+
+func Test_RetryableOncer_panic(t *testing.T) {
+	tests := map[string]struct {
+		opts      []RetryableOncerOption
+		wantPanic bool
+		wantState StateValue
+		wantErr   bool
+	}{
+		"Panic without recovery": {
+			wantPanic: true,
+			wantState: RetryState,
+		},
+		"Recover as retryable": {
+			opts:      []RetryableOncerOption{WithPanicRecovery(true)},
+			wantState: RetryState,
+			wantErr:   true,
+		},
+		"Recover as permanent": {
+			opts:      []RetryableOncerOption{WithPanicRecovery(false)},
+			wantState: FailedState,
+			wantErr:   true,
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			r := NewMemoryStateRepository()
+			retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r, tc.opts...)
+
+			func() {
+				defer func() {
+					if v := recover(); (v != nil) != tc.wantPanic {
+						t.Errorf("unexpected panic: %v", v)
+					}
+				}()
+				err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+					panic("boom")
+				})
+				if _, ok := err.(*PanicError); ok != tc.wantErr {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+
+			st, _ := r.GetState(context.TODO(), "retryableKey")
+			if st.Value != tc.wantState || st.Attempts != 1 {
+				t.Errorf("unexpected state: %+v", st)
+			}
+
+			counter := 0
+			retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+				counter++
+				return nil
+			})
+			want := 0
+			if tc.wantState == RetryState {
+				want = 1
+			}
+			if counter != want {
+				t.Errorf("unexpected execution time: %d", counter)
+			}
+		})
+	}
+}
+
+func Test_RetryableOncer_WithAttemptTimeout(t *testing.T) {
+	var heartbeats int32
+	r := &recordingStateRepository{
+		MemoryStateRepository: NewMemoryStateRepository(),
+		fn: func(state State) {
+			if state.Value == RunningState {
+				if state.Owner != "owner" {
+					t.Errorf("unexpected owner: %s", state.Owner)
+				}
+				atomic.AddInt32(&heartbeats, 1)
+			}
+		},
+	}
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r,
+		WithAttemptTimeout(100*time.Millisecond), WithHeartbeatInterval(10*time.Millisecond), WithOwner("owner"))
+
+	err := retryableOncer.DoContext(context.TODO(), "retryableKey", func(ctx context.Context) error {
+		if st, _ := r.GetState(context.TODO(), "retryableKey"); st.Value != RunningState {
+			t.Errorf("unexpected state: %+v", st)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if rerr, ok := err.(interface {
+		CanRetry() bool
+	}); !ok || !rerr.CanRetry() {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&heartbeats); n < 3 {
+		t.Errorf("unexpected heartbeats: %d", n)
+	}
+
+	if st, _ := r.GetState(context.TODO(), "retryableKey"); st.Value != RetryState || st.Attempts != 1 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_RetryableOncer_running(t *testing.T) {
+	now := time.Now()
+	r := NewMemoryStateRepository()
+	r.UpdateState(context.TODO(), "retryableKey", State{
+		Attempts:  1,
+		Value:     RunningState,
+		Owner:     "another",
+		Heartbeat: now,
+	})
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r, WithAttemptTimeout(time.Minute))
+	retryableOncer.now = func() time.Time { return now }
+
+	counter := 0
+	fn := func() error {
+		counter++
+		return nil
+	}
+
+	// the attempt of another is running
+	err := retryableOncer.Do(context.TODO(), "retryableKey", fn)
+	if rerr, ok := err.(interface {
+		CanRetry() bool
+	}); !ok || !rerr.CanRetry() {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the attempt of another is stalled
+	now = now.Add(2 * time.Minute)
+	if err := retryableOncer.Do(context.TODO(), "retryableKey", fn); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if counter != 1 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+
+	if st, _ := r.GetState(context.TODO(), "retryableKey"); st.Value != DoneState || st.Attempts != 2 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_RetryableOncer_without_StateSwapper(t *testing.T) {
+	// hide StateSwapper
+	r := struct{ StateRepository }{NewMemoryStateRepository()}
+
+	counter := 0
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r, WithAttemptTimeout(time.Minute))
+	if err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+		counter++
+		return nil
+	}); err == nil {
+		t.Errorf("attempt timeout is allowed")
+	}
+	if counter != 0 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+
+	retryableOncer = NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r)
+	if err := retryableOncer.Cancel(context.TODO(), "retryableKey", "reason"); err == nil {
+		t.Errorf("cancel is allowed")
+	}
+	if st, _ := r.GetState(context.TODO(), "retryableKey"); st.Value != InitState {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_RetryableOncer_Cancel(t *testing.T) {
+	tests := map[string]struct {
+		before    func(r *RetryableOncer)
+		during    bool
+		result    error
+		wantErr   bool
+		wantCount int
+	}{
+		"Cancel before execution": {
+			before: func(r *RetryableOncer) {},
+		},
+		"Cancel retry": {
+			before: func(r *RetryableOncer) {
+				r.Do(context.TODO(), "retryableKey", func() error {
+					return &retryableError{errors.New("retry")}
+				})
+			},
+		},
+		"Cancel running": {
+			during:    true,
+			result:    &retryableError{errors.New("retry")},
+			wantCount: 1,
+		},
+		"Cancel running done": {
+			during:    true,
+			wantErr:   true,
+			wantCount: 1,
+		},
+		"Cancel running failed": {
+			during:    true,
+			result:    errors.New("failed"),
+			wantErr:   true,
+			wantCount: 1,
+		},
+		"Cancel done": {
+			before: func(r *RetryableOncer) {
+				r.Do(context.TODO(), "retryableKey", func() error { return nil })
+			},
+			wantErr: true,
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			r := NewMemoryStateRepository()
+			retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), r, WithAttemptTimeout(time.Minute))
+
+			counter := 0
+			fn := func() error {
+				counter++
+				if tc.during {
+					if err := retryableOncer.Cancel(context.TODO(), "retryableKey", "reason"); err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					return tc.result
+				}
+				return nil
+			}
+
+			if tc.before != nil {
+				tc.before(retryableOncer)
+				err := retryableOncer.Cancel(context.TODO(), "retryableKey", "reason")
+				if (err != nil) != tc.wantErr {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+
+			for i := 0; i < 3; i++ {
+				err := retryableOncer.Do(context.TODO(), "retryableKey", fn)
+				if cerr, ok := err.(*ErrCancelled); ok == tc.wantErr || (ok && cerr.Reason != "reason") {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+
+			if counter != tc.wantCount {
+				t.Errorf("unexpected execution time: %d", counter)
+			}
+
+			st, _ := r.GetState(context.TODO(), "retryableKey")
+			if (st.Value == CancelledState) == tc.wantErr {
+				t.Errorf("unexpected state: %+v", st)
+			}
+		})
+	}
+}
+
 func Test_retryableError(t *testing.T) {
 	err := &retryableError{errors.New("error")}
 
@@ -0,0 +1,308 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sync"
+)
+
+const (
+	defaultBloomFalsePositiveRate = 0.01
+	defaultBloomCapacity          = 100000
+
+	// bloomGrowth is the ratio of the capacity of the next filter.
+	bloomGrowth = 2
+	// bloomTightening is the ratio of the false positive rate of the next filter.
+	bloomTightening = 0.5
+)
+
+type notSeenContextKey struct{}
+
+// NotSeenByFilter reports whether the key of Store has not been seen by the filter of BloomRepository.
+// The filter knows only the keys stored through it on this process, so the key may still exist
+// in the backing SyncRepository, for example stored by another process or before a restart without Restore.
+// It is only a hint, and the backing SyncRepository must check key as usual.
+func NotSeenByFilter(ctx context.Context) bool {
+	v, _ := ctx.Value(notSeenContextKey{}).(bool)
+	return v
+}
+
+// bloomFilter is a bloom filter with the fixed capacity.
+type bloomFilter struct {
+	Bits     []byte `json:"bits"`
+	M        uint64 `json:"m"`
+	K        int    `json:"k"`
+	Capacity int    `json:"capacity"`
+	Count    int    `json:"count"`
+}
+
+func newBloomFilter(capacity int, p float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloomFilter{
+		Bits:     make([]byte, (m+7)/8),
+		M:        m,
+		K:        k,
+		Capacity: capacity,
+	}
+}
+
+// add sets the bits of the hashes by the double hashing.
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := 0; i < f.K; i++ {
+		n := (h1 + uint64(i)*h2) % f.M
+		f.Bits[n/8] |= 1 << (n % 8)
+	}
+	f.Count++
+}
+
+func (f *bloomFilter) contains(h1, h2 uint64) bool {
+	for i := 0; i < f.K; i++ {
+		n := (h1 + uint64(i)*h2) % f.M
+		if f.Bits[n/8]&(1<<(n%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) valid() bool {
+	return f.M > 0 && uint64(len(f.Bits)) == (f.M+7)/8 && f.K > 0 && f.Capacity > 0
+}
+
+func bloomHash(key string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	sum := h.Sum(nil)
+	// h2 must be odd not to repeat the same bits
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+// BloomRepository implements SyncRepository interface which pre-checks keys by a scalable bloom filter
+// in front of the backing SyncRepository.
+//
+// Store always stores key to the backing SyncRepository, so the correctness comes from it alone.
+// If the filter has not seen key, Store tells it to the backing SyncRepository by the context as a hint.
+// See NotSeenByFilter. BloomRepository has no fast path by itself, and every Store reaches the backing
+// SyncRepository. TieredRepository uses the hint to skip its local cache.
+//
+// The filter grows by adding a larger filter when it is full, so the false positive rate is kept
+// regardless of the number of keys. The filter can be saved and restored by Snapshotter.
+type BloomRepository struct {
+	mu      sync.RWMutex
+	r       SyncRepository
+	filters []*bloomFilter
+	p       float64
+	n       int
+
+	notSeen        uint64
+	maybeSeen      uint64
+	falsePositives uint64
+}
+
+// BloomRepositoryStats is statistics of BloomRepository
+type BloomRepositoryStats struct {
+	// Keys is the number of keys added to the filter.
+	Keys int
+	// Filters is the number of filters.
+	Filters int
+	// Bytes is the total size of filters.
+	Bytes int
+	// NotSeen is the number of keys which the filter has not seen.
+	NotSeen uint64
+	// MaybeSeen is the number of keys which the filter says maybe seen.
+	MaybeSeen uint64
+	// FalsePositives is the number of keys which the filter says maybe seen but are stored as new.
+	FalsePositives uint64
+}
+
+// BloomRepositoryOption is an option for BloomRepository
+type BloomRepositoryOption func(r *BloomRepository)
+
+// WithBloomFalsePositiveRate sets the false positive rate of the filter. The default is 0.01.
+// p must be greater than 0 and less than 1.
+func WithBloomFalsePositiveRate(p float64) BloomRepositoryOption {
+	return func(r *BloomRepository) {
+		r.p = p
+	}
+}
+
+// WithBloomCapacity sets the number of keys of the first filter. The default is 100000.
+// The following filters have twice capacity of the previous one. n must be positive.
+func WithBloomCapacity(n int) BloomRepositoryOption {
+	return func(r *BloomRepository) {
+		r.n = n
+	}
+}
+
+// NewBloomRepository creates a BloomRepository instance.
+// NewBloomRepository returns an error if the options are invalid.
+func NewBloomRepository(r SyncRepository, opts ...BloomRepositoryOption) (*BloomRepository, error) {
+	br := &BloomRepository{
+		r: r,
+		p: defaultBloomFalsePositiveRate,
+		n: defaultBloomCapacity,
+	}
+	for _, opt := range opts {
+		opt(br)
+	}
+	if br.n <= 0 {
+		return nil, fmt.Errorf("invalid capacity: %d", br.n)
+	}
+	if !(br.p > 0 && br.p < 1) {
+		return nil, fmt.Errorf("invalid false positive rate: %v", br.p)
+	}
+
+	return br, nil
+}
+
+// MayContain reports whether key may be stored through the filter.
+// If it returns false, key has not been seen by the filter, but it may exist in the backing SyncRepository.
+func (r *BloomRepository) MayContain(key string) bool {
+	h1, h2 := bloomHash(key)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.contains(h1, h2)
+}
+
+func (r *BloomRepository) contains(h1, h2 uint64) bool {
+	for _, f := range r.filters {
+		if f.contains(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the hashes to the last filter, or to a new filter if the last filter is full.
+// The false positive rate of the i-th filter is p * (1 - r) * r^i, so the total rate is bounded by p.
+func (r *BloomRepository) add(h1, h2 uint64) {
+	if r.contains(h1, h2) {
+		return
+	}
+
+	if len(r.filters) == 0 || r.filters[len(r.filters)-1].Count >= r.filters[len(r.filters)-1].Capacity {
+		i := len(r.filters)
+		capacity := r.n * int(math.Pow(bloomGrowth, float64(i)))
+		p := r.p * (1 - bloomTightening) * math.Pow(bloomTightening, float64(i))
+		r.filters = append(r.filters, newBloomFilter(capacity, p))
+	}
+	r.filters[len(r.filters)-1].add(h1, h2)
+}
+
+// Store stores key to the backing SyncRepository, then adds key to the filter.
+// If key is already exists, the backing SyncRepository returns duplicate error.
+func (r *BloomRepository) Store(ctx context.Context, key string) error {
+	h1, h2 := bloomHash(key)
+
+	r.mu.RLock()
+	seen := r.contains(h1, h2)
+	r.mu.RUnlock()
+
+	err := r.r.Store(context.WithValue(ctx, notSeenContextKey{}, !seen), key)
+	duplicated := false
+	if v, ok := err.(interface {
+		Duplicate() bool
+	}); ok && v.Duplicate() {
+		duplicated = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seen {
+		r.maybeSeen++
+		if err == nil {
+			r.falsePositives++
+		}
+	} else {
+		r.notSeen++
+	}
+	if err == nil || duplicated {
+		r.add(h1, h2)
+	}
+
+	return err
+}
+
+// Stats returns statistics.
+func (r *BloomRepository) Stats() BloomRepositoryStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := BloomRepositoryStats{
+		Filters:        len(r.filters),
+		NotSeen:        r.notSeen,
+		MaybeSeen:      r.maybeSeen,
+		FalsePositives: r.falsePositives,
+	}
+	for _, f := range r.filters {
+		stats.Keys += f.Count
+		stats.Bytes += len(f.Bits)
+	}
+
+	return stats
+}
+
+// Snapshot writes the filters to w.
+func (r *BloomRepository) Snapshot(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sw, err := newSnapshotWriter(w, bloomSnapshot)
+	if err != nil {
+		return err
+	}
+	for _, f := range r.filters {
+		if err := sw.enc.Encode(f); err != nil {
+			return err
+		}
+	}
+
+	return sw.flush()
+}
+
+// Restore replaces the filters with the snapshot of rd.
+// The keys of the backing SyncRepository are not restored.
+func (r *BloomRepository) Restore(rd io.Reader) error {
+	dec, err := newSnapshotDecoder(rd, bloomSnapshot)
+	if err != nil {
+		return err
+	}
+
+	var filters []*bloomFilter
+	for {
+		var f bloomFilter
+		err := dec.Decode(&f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid snapshot entry: %v", err)
+		}
+		if !f.valid() {
+			return errors.New("invalid snapshot entry: broken filter")
+		}
+		filters = append(filters, &f)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.filters = filters
+	return nil
+}
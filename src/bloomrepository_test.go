@@ -0,0 +1,223 @@
+package atomicop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// hintRepository records the hints of NotSeenByFilter.
+type hintRepository struct {
+	*SyncMapRepository
+	hints map[string]bool
+}
+
+func (r *hintRepository) Store(ctx context.Context, key string) error {
+	r.hints[key] = NotSeenByFilter(ctx)
+	return r.SyncMapRepository.Store(ctx, key)
+}
+
+func newBloomRepository(t *testing.T, backing SyncRepository, opts ...BloomRepositoryOption) *BloomRepository {
+	r, err := NewBloomRepository(backing, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func Test_NewBloomRepository(t *testing.T) {
+	tests := map[string]struct {
+		opts    []BloomRepositoryOption
+		wantErr bool
+	}{
+		"default":              {},
+		"valid":                {opts: []BloomRepositoryOption{WithBloomFalsePositiveRate(0.5), WithBloomCapacity(1)}},
+		"zero capacity":        {opts: []BloomRepositoryOption{WithBloomCapacity(0)}, wantErr: true},
+		"negative capacity":    {opts: []BloomRepositoryOption{WithBloomCapacity(-1)}, wantErr: true},
+		"zero rate":            {opts: []BloomRepositoryOption{WithBloomFalsePositiveRate(0)}, wantErr: true},
+		"rate of one":          {opts: []BloomRepositoryOption{WithBloomFalsePositiveRate(1)}, wantErr: true},
+		"rate of not a number": {opts: []BloomRepositoryOption{WithBloomFalsePositiveRate(math.NaN())}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewBloomRepository(NewSyncMapRepository(), tt.opts...); (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_BloomSyncRepository(t *testing.T) {
+	testSyncRepository(t, newBloomRepository(t, NewSyncMapRepository(), WithBloomCapacity(10)))
+}
+
+func Test_BloomRepository_Store(t *testing.T) {
+	backing := &hintRepository{SyncMapRepository: NewSyncMapRepository(), hints: map[string]bool{}}
+	r := newBloomRepository(t, backing)
+	ctx := context.TODO()
+
+	// the key stored without the filter is checked by the backing repository
+	if err := backing.SyncMapRepository.Store(ctx, "stored"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(ctx, "stored"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the filter has not seen the key even though it exists in the backing repository
+	if !backing.hints["stored"] {
+		t.Errorf("stored is seen by the filter")
+	}
+
+	if err := r.Store(ctx, "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !backing.hints["key"] {
+		t.Errorf("key is seen by the filter")
+	}
+	if err := r.Store(ctx, "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if backing.hints["key"] {
+		t.Errorf("key is not seen by the filter")
+	}
+
+	if stats := r.Stats(); stats.Keys != 2 || stats.NotSeen != 2 || stats.MaybeSeen != 1 || stats.FalsePositives != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func Test_BloomRepository_with_TieredRepository(t *testing.T) {
+	shared := newCountingRepository()
+	tiered := NewTieredRepository(shared, nil)
+	ctx := context.TODO()
+
+	// the key is cached by the tiered repository
+	tiered.Store(ctx, "key")
+	tiered.Store(ctx, "key")
+	if err := tiered.Store(ctx, "key"); !duplicated(err) || shared.stores != 2 {
+		t.Fatalf("unexpected error: %v, %d", err, shared.stores)
+	}
+
+	// the key which the filter has not seen skips the cache of the tiered repository
+	r := newBloomRepository(t, tiered)
+	if err := r.Store(ctx, "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if shared.stores != 3 || tiered.Stats().KeyHits != 1 {
+		t.Errorf("unexpected calls: %d, %+v", shared.stores, tiered.Stats())
+	}
+
+	// the key which the filter may have seen is answered by the cache
+	if err := r.Store(ctx, "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if shared.stores != 3 || tiered.Stats().KeyHits != 2 {
+		t.Errorf("unexpected calls: %d, %+v", shared.stores, tiered.Stats())
+	}
+}
+
+func Test_BloomRepository_false_positive_rate(t *testing.T) {
+	tests := map[string]struct {
+		p        float64
+		capacity int
+		filters  int
+	}{
+		"single": {p: 0.01, capacity: 10000, filters: 1},
+		"scaled": {p: 0.01, capacity: 100, filters: 7},
+		"strict": {p: 0.001, capacity: 1000, filters: 4},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newBloomRepository(t, NewSyncMapRepository(), WithBloomFalsePositiveRate(tt.p), WithBloomCapacity(tt.capacity))
+			for i := 0; i < 10000; i++ {
+				if err := r.Store(context.TODO(), fmt.Sprintf("key%d", i)); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			for i := 0; i < 10000; i++ {
+				if !r.MayContain(fmt.Sprintf("key%d", i)) {
+					t.Fatalf("false negative: key%d", i)
+				}
+			}
+
+			positives := 0
+			for i := 0; i < 100000; i++ {
+				if r.MayContain(fmt.Sprintf("other%d", i)) {
+					positives++
+				}
+			}
+			if rate := float64(positives) / 100000; rate > tt.p*1.5 {
+				t.Errorf("unexpected false positive rate: %f", rate)
+			}
+			if stats := r.Stats(); stats.Filters != tt.filters || stats.Keys+int(stats.FalsePositives) != 10000 {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+		})
+	}
+}
+
+func Test_BloomRepository_Snapshot(t *testing.T) {
+	r := newBloomRepository(t, NewSyncMapRepository(), WithBloomCapacity(100))
+	for i := 0; i < 1000; i++ {
+		r.Store(context.TODO(), fmt.Sprintf("key%d", i))
+	}
+
+	var buf bytes.Buffer
+	if err := r.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	restored := newBloomRepository(t, NewSyncMapRepository(), WithBloomCapacity(100))
+	if err := restored.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if !restored.MayContain(fmt.Sprintf("key%d", i)) {
+			t.Fatalf("false negative: key%d", i)
+		}
+	}
+	if got, want := restored.Stats(), r.Stats(); got.Keys != want.Keys || got.Filters != want.Filters || got.Bytes != want.Bytes {
+		t.Errorf("unexpected stats: %+v, %+v", got, want)
+	}
+
+	// the keys of the backing repository are not restored
+	if err := restored.Store(context.TODO(), "key0"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if stats := restored.Stats(); stats.MaybeSeen != 1 || stats.FalsePositives != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func Test_BloomRepository_Restore(t *testing.T) {
+	tests := map[string]struct {
+		snapshot string
+		wantErr  bool
+	}{
+		"empty filter": {
+			snapshot: `{"format":"atomicop-snapshot","version":1,"kind":"bloom"}` + "\n",
+		},
+		"sync snapshot": {
+			snapshot: `{"format":"atomicop-snapshot","version":1,"kind":"sync"}` + "\n" + `{"key":"key-1"}` + "\n",
+			wantErr:  true,
+		},
+		"broken filter": {
+			snapshot: `{"format":"atomicop-snapshot","version":1,"kind":"bloom"}` + "\n" + `{"bits":"AA==","m":64,"k":1,"capacity":1}` + "\n",
+			wantErr:  true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newBloomRepository(t, NewSyncMapRepository())
+			if err := r.Restore(strings.NewReader(tt.snapshot)); (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package atomicop
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltKeysBucket     = []byte("keys")
+	boltStatesBucket   = []byte("states")
+	boltAttemptsBucket = []byte("attempts")
+	boltExpiryBucket   = []byte("expiry")
+)
+
+// BoltRepository implements SyncRepository, StateRepository and StateSwapper interface using bbolt.
+// Keys, states and the number of attempts are saved to the separate buckets,
+// and the expiration time of them is indexed by the expiry bucket.
+// Writes are coalesced into a transaction by bolt.DB.Batch,
+// so that the concurrent writes share the cost of fsync.
+type BoltRepository struct {
+	db  *bolt.DB
+	ttl time.Duration
+	now func() time.Time
+}
+
+// BoltRepositoryOption is an option for BoltRepository
+type BoltRepositoryOption func(r *BoltRepository)
+
+// WithBoltTTL expires keys and states after ttl from the last update.
+// The expired key is regarded as unknown key, and it is removed by Cleanup.
+func WithBoltTTL(ttl time.Duration) BoltRepositoryOption {
+	return func(r *BoltRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewBoltRepository creates a BoltRepository instance, and creates the buckets if not exist.
+func NewBoltRepository(db *bolt.DB, opts ...BoltRepositoryOption) (*BoltRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltKeysBucket, boltStatesBucket, boltAttemptsBucket, boltExpiryBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &BoltRepository{
+		db:  db,
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r, nil
+}
+
+// boltEntry is the value of keys and states bucket.
+// The first 8 bytes are the expiration time in unix nanoseconds, and zero means no expiration.
+type boltEntry []byte
+
+func newBoltEntry(expiresAt time.Time, value []byte) boltEntry {
+	e := make(boltEntry, 8+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(e, uint64(expiresAt.UnixNano()))
+	}
+	copy(e[8:], value)
+	return e
+}
+
+func (e boltEntry) expiresAt() uint64 {
+	return binary.BigEndian.Uint64(e)
+}
+
+func (e boltEntry) expired(now time.Time) bool {
+	at := e.expiresAt()
+	return at != 0 && at <= uint64(now.UnixNano())
+}
+
+func (e boltEntry) value() []byte {
+	return e[8:]
+}
+
+// expiryKey is the key of expiry bucket which is sorted by the expiration time.
+func expiryKey(expiresAt uint64, bucket []byte, key string) []byte {
+	k := make([]byte, 8, 8+len(bucket)+1+len(key))
+	binary.BigEndian.PutUint64(k, expiresAt)
+	k = append(k, bucket...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// get returns the entry of key in bucket. An expired entry is regarded as not found.
+func (r *BoltRepository) get(tx *bolt.Tx, bucket []byte, key string) boltEntry {
+	e := boltEntry(tx.Bucket(bucket).Get([]byte(key)))
+	if e == nil || e.expired(r.now()) {
+		return nil
+	}
+	return e
+}
+
+// put saves value of key to bucket, and replaces the expiry index of key.
+func (r *BoltRepository) put(tx *bolt.Tx, bucket []byte, key string, value []byte) error {
+	b := tx.Bucket(bucket)
+	expiry := tx.Bucket(boltExpiryBucket)
+	if old := boltEntry(b.Get([]byte(key))); old != nil && old.expiresAt() != 0 {
+		if err := expiry.Delete(expiryKey(old.expiresAt(), bucket, key)); err != nil {
+			return err
+		}
+	}
+
+	var expiresAt time.Time
+	if r.ttl > 0 {
+		expiresAt = r.now().Add(r.ttl)
+	}
+	e := newBoltEntry(expiresAt, value)
+	if err := b.Put([]byte(key), e); err != nil {
+		return err
+	}
+	if e.expiresAt() != 0 {
+		return expiry.Put(expiryKey(e.expiresAt(), bucket, key), nil)
+	}
+
+	return nil
+}
+
+// batch runs fn by bolt.DB.Batch.
+// The error set to result by fn is returned without rolling back the other writes of the batch.
+// fn may be called multiple times, so that result is reset by every call.
+func (r *BoltRepository) batch(fn func(tx *bolt.Tx, result *error) error) error {
+	var result error
+	err := r.db.Batch(func(tx *bolt.Tx) error {
+		result = nil
+		return fn(tx, &result)
+	})
+	if err != nil {
+		return err
+	}
+
+	return result
+}
+
+// Store stores key.
+// If key is already exists, this method returns duplicateError
+func (r *BoltRepository) Store(ctx context.Context, key string) error {
+	return r.batch(func(tx *bolt.Tx, result *error) error {
+		if r.get(tx, boltKeysBucket, key) != nil {
+			*result = &duplicateError{errors.New("duplicated")}
+			return nil
+		}
+		return r.put(tx, boltKeysBucket, key, nil)
+	})
+}
+
+// Exists reports whether key is stored.
+func (r *BoltRepository) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		exists = r.get(tx, boltKeysBucket, key) != nil
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// GetState gets state.
+// If key is not found or expired, GetState returns initial state.
+func (r *BoltRepository) GetState(ctx context.Context, key string) (*State, error) {
+	state := &State{Attempts: 0, Value: InitState}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return r.load(tx, key, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (r *BoltRepository) load(tx *bolt.Tx, key string, state *State) error {
+	e := r.get(tx, boltStatesBucket, key)
+	if e == nil {
+		return nil
+	}
+	return json.Unmarshal(e.value(), state)
+}
+
+// Attempts returns the number of attempts of key without decoding state.
+func (r *BoltRepository) Attempts(ctx context.Context, key string) (int, error) {
+	var attempts int
+	err := r.db.View(func(tx *bolt.Tx) error {
+		if r.get(tx, boltStatesBucket, key) == nil {
+			return nil
+		}
+		if v := tx.Bucket(boltAttemptsBucket).Get([]byte(key)); v != nil {
+			attempts = int(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+
+	return attempts, err
+}
+
+// UpdateState updates state.
+func (r *BoltRepository) UpdateState(ctx context.Context, key string, state State) error {
+	_, err := r.swap(key, func(current int64) error { return nil }, state)
+	return err
+}
+
+// CompareAndSwapState updates state only if the version of saved state equals version.
+func (r *BoltRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	return r.swap(key, func(current int64) error {
+		if current != version {
+			return &conflictError{fmt.Errorf("version mismatch: %d != %d", version, current)}
+		}
+		return nil
+	}, state)
+}
+
+// swap saves state with the next version if check is passed.
+func (r *BoltRepository) swap(key string, check func(current int64) error, state State) (int64, error) {
+	var version int64
+	err := r.batch(func(tx *bolt.Tx, result *error) error {
+		current := State{}
+		if err := r.load(tx, key, &current); err != nil {
+			return err
+		}
+		if err := check(current.Version); err != nil {
+			*result = err
+			return nil
+		}
+
+		next := state
+		next.Version = current.Version + 1
+		encoded, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		if err := r.put(tx, boltStatesBucket, key, encoded); err != nil {
+			return err
+		}
+		attempts := make([]byte, 8)
+		binary.BigEndian.PutUint64(attempts, uint64(next.Attempts))
+		if err := tx.Bucket(boltAttemptsBucket).Put([]byte(key), attempts); err != nil {
+			return err
+		}
+
+		version = next.Version
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// Cleanup removes expired keys and states by the expiry index, and returns the number of removed entries.
+func (r *BoltRepository) Cleanup(ctx context.Context) (int, error) {
+	removed := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		// the index is collected before deleting not to skip keys by the cursor.
+		now := uint64(r.now().UnixNano())
+		expiry := tx.Bucket(boltExpiryBucket)
+		var keys [][]byte
+		c := expiry.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= now; k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+
+		for _, k := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			i := bytes.IndexByte(k[8:], 0)
+			bucket, key := k[8:8+i], k[8+i+1:]
+			if err := tx.Bucket(bucket).Delete(key); err != nil {
+				return err
+			}
+			if bytes.Equal(bucket, boltStatesBucket) {
+				if err := tx.Bucket(boltAttemptsBucket).Delete(key); err != nil {
+					return err
+				}
+			}
+			if err := expiry.Delete(k); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
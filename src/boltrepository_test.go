@@ -0,0 +1,92 @@
+package atomicop
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newBoltRepository(t *testing.T, opts ...BoltRepositoryOption) *BoltRepository {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "atomicop.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	r, err := NewBoltRepository(db, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func Test_BoltSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, newBoltRepository(t))
+}
+
+func Test_BoltStateRepository(t *testing.T) {
+	t.Parallel()
+
+	r := newBoltRepository(t)
+	testStateRepository(t, r)
+
+	if n, err := r.Attempts(context.TODO(), "stateKey"); err != nil || n != 2 {
+		t.Errorf("unexpected attempts: %d, %v", n, err)
+	}
+}
+
+func Test_BoltRepository_WithBoltTTL(t *testing.T) {
+	t.Parallel()
+
+	r := newBoltRepository(t, WithBoltTTL(time.Minute))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.Store(context.TODO(), "another"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	now = now.Add(time.Minute)
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+	if n, _ := r.Attempts(context.TODO(), "key"); n != 0 {
+		t.Errorf("unexpected attempts: %d", n)
+	}
+
+	// "key" is stored again just now, "another" and the state are expired.
+	n, err := r.Cleanup(context.TODO())
+	if err != nil || n != 2 {
+		t.Errorf("unexpected result: %d, %v", n, err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func prepareBolt(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := newBoltRepository(t)
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_Bolt(t *testing.T) {
+	testRetryableOncer(t, prepareBolt)
+}
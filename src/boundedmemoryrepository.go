@@ -0,0 +1,167 @@
+package atomicop
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy decides which key is evicted first from BoundedMemoryRepository
+type EvictionPolicy int
+
+const (
+	// EvictOldest evicts the least recently stored key
+	EvictOldest EvictionPolicy = iota
+	// EvictLeastRecentlyUsed evicts the least recently stored or duplicated key
+	EvictLeastRecentlyUsed
+)
+
+// BoundedMemoryRepository implements SyncRepository interface on memory with bounded size.
+//
+// Attention: the evicted or expired key is regarded as unknown key.
+// Therefore, the operation of the evicted key can be executed again.
+// The limits must be large enough to keep keys while they can be duplicated.
+type BoundedMemoryRepository struct {
+	mu     sync.Mutex
+	keys   *lru
+	policy EvictionPolicy
+	now    func() time.Time
+}
+
+// BoundedMemoryRepositoryStats is statistics of BoundedMemoryRepository
+type BoundedMemoryRepositoryStats struct {
+	// Entries is the number of stored keys.
+	Entries int
+	// Bytes is the total length of stored keys.
+	Bytes int
+	// Evictions is the number of keys evicted by the limits.
+	Evictions uint64
+	// Expirations is the number of keys expired by TTL.
+	Expirations uint64
+}
+
+// BoundedMemoryRepositoryOption is an option for BoundedMemoryRepository
+type BoundedMemoryRepositoryOption func(r *BoundedMemoryRepository)
+
+// WithMaxEntries limits the number of keys.
+func WithMaxEntries(n int) BoundedMemoryRepositoryOption {
+	return func(r *BoundedMemoryRepository) {
+		r.keys.maxEntries = n
+	}
+}
+
+// WithMaxBytes limits the total length of keys.
+func WithMaxBytes(n int) BoundedMemoryRepositoryOption {
+	return func(r *BoundedMemoryRepository) {
+		r.keys.maxBytes = n
+	}
+}
+
+// WithKeyTTL expires keys after ttl from stored.
+func WithKeyTTL(ttl time.Duration) BoundedMemoryRepositoryOption {
+	return func(r *BoundedMemoryRepository) {
+		r.keys.ttl = ttl
+	}
+}
+
+// WithEvictionPolicy sets the eviction policy. The default is EvictOldest.
+func WithEvictionPolicy(policy EvictionPolicy) BoundedMemoryRepositoryOption {
+	return func(r *BoundedMemoryRepository) {
+		r.policy = policy
+	}
+}
+
+// NewBoundedMemoryRepository creates a BoundedMemoryRepository instance.
+// Without any limits, BoundedMemoryRepository keeps every keys as same as SyncMapRepository.
+func NewBoundedMemoryRepository(opts ...BoundedMemoryRepositoryOption) *BoundedMemoryRepository {
+	r := &BoundedMemoryRepository{
+		keys: newLRU(0, 0, 0),
+		now:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Store stores key.
+// If key is already exists, this method returns duplicateError
+func (r *BoundedMemoryRepository) Store(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if _, ok := r.keys.get(key, now, r.policy == EvictLeastRecentlyUsed); ok {
+		return &duplicateError{errors.New("duplicated")}
+	}
+	r.keys.add(key, struct{}{}, now)
+
+	return nil
+}
+
+// Exists reports whether key is stored.
+// The key is not touched for the eviction.
+func (r *BoundedMemoryRepository) Exists(ctx context.Context, key string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.keys.get(key, r.now(), false)
+	return ok, nil
+}
+
+// Stats returns statistics.
+func (r *BoundedMemoryRepository) Stats() BoundedMemoryRepositoryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return BoundedMemoryRepositoryStats{
+		Entries:     r.keys.len(),
+		Bytes:       r.keys.bytes,
+		Evictions:   r.keys.evictions,
+		Expirations: r.keys.expirations,
+	}
+}
+
+// Snapshot writes all keys to w from the oldest.
+func (r *BoundedMemoryRepository) Snapshot(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sw, err := newSnapshotWriter(w, syncSnapshot)
+	if err != nil {
+		return err
+	}
+
+	r.keys.each(r.now(), func(ent *lruEntry) bool {
+		err = sw.write(ent.key, nil, ent.expiresAt)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sw.flush()
+}
+
+// Restore adds keys from the snapshot of rd in order.
+// The keys which are already expired are skipped, and the keys over the limits are evicted.
+func (r *BoundedMemoryRepository) Restore(rd io.Reader) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	return readSnapshot(rd, syncSnapshot, func(e snapshotEntry) error {
+		expiresAt := e.expiresAt()
+		if expiresAt.IsZero() && r.keys.ttl > 0 {
+			expiresAt = now.Add(r.keys.ttl)
+		}
+		if !expiresAt.IsZero() && !now.Before(expiresAt) {
+			return nil
+		}
+		r.keys.put(e.Key, struct{}{}, expiresAt, now)
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package atomicop
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_BoundedMemoryRepository(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		key       string
+		duplicate bool
+	}
+	tests := map[string]struct {
+		opts      []BoundedMemoryRepositoryOption
+		steps     []step
+		wantStats BoundedMemoryRepositoryStats
+	}{
+		"unbounded": {
+			steps: []step{
+				{key: "a"}, {key: "b"}, {key: "c"}, {key: "a", duplicate: true},
+			},
+			wantStats: BoundedMemoryRepositoryStats{Entries: 3, Bytes: 3},
+		},
+		"max entries evicts oldest": {
+			opts: []BoundedMemoryRepositoryOption{WithMaxEntries(2)},
+			steps: []step{
+				{key: "a"}, {key: "b"}, {key: "a", duplicate: true}, {key: "c"}, {key: "a"},
+			},
+			wantStats: BoundedMemoryRepositoryStats{Entries: 2, Bytes: 2, Evictions: 2},
+		},
+		"max entries evicts least recently used": {
+			opts: []BoundedMemoryRepositoryOption{WithMaxEntries(2), WithEvictionPolicy(EvictLeastRecentlyUsed)},
+			steps: []step{
+				{key: "a"}, {key: "b"}, {key: "a", duplicate: true}, {key: "c"}, {key: "a", duplicate: true}, {key: "b"},
+			},
+			wantStats: BoundedMemoryRepositoryStats{Entries: 2, Bytes: 2, Evictions: 2},
+		},
+		"max bytes": {
+			opts: []BoundedMemoryRepositoryOption{WithMaxBytes(5)},
+			steps: []step{
+				{key: "aa"}, {key: "bb"}, {key: "cc"}, {key: "aa"}, {key: "cc", duplicate: true},
+			},
+			wantStats: BoundedMemoryRepositoryStats{Entries: 2, Bytes: 4, Evictions: 2},
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			r := NewBoundedMemoryRepository(tc.opts...)
+			for _, s := range tc.steps {
+				err := r.Store(context.TODO(), s.key)
+				if v, ok := err.(interface {
+					Duplicate() bool
+				}); (ok && v.Duplicate()) != s.duplicate {
+					t.Errorf("unexpected error of %s: %v", s.key, err)
+				}
+			}
+
+			if stats := r.Stats(); stats != tc.wantStats {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+		})
+	}
+}
+
+func Test_BoundedMemoryRepository_WithKeyTTL(t *testing.T) {
+	t.Parallel()
+
+	r := NewBoundedMemoryRepository(WithKeyTTL(time.Minute))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if err := r.Store(context.TODO(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Store(context.TODO(), "a"); err == nil {
+		t.Errorf("unexpected success")
+	}
+
+	now = now.Add(time.Minute)
+	if exists, _ := r.Exists(context.TODO(), "a"); exists {
+		t.Errorf("expired key exists")
+	}
+	if err := r.Store(context.TODO(), "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if stats := r.Stats(); stats.Entries != 1 || stats.Expirations != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func Test_BoundedMemoryRepository_parallel(t *testing.T) {
+	t.Parallel()
+
+	oncer := NewOnce(NewBoundedMemoryRepository(WithMaxEntries(10)))
+
+	var counter int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := oncer.Do(context.TODO(), "same", func() error {
+				atomic.AddInt32(&counter, 1)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if counter != 1 {
+		t.Errorf("unexpected execution times: %d", counter)
+	}
+}
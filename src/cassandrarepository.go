@@ -0,0 +1,174 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// CassandraRepository implements SyncRepository, StateRepository and StateSwapper interface
+// using Cassandra or ScyllaDB with lightweight transactions.
+// Keys and states are saved to the tables created by docker/cassandra/schema.cql.
+// The session should read at QUORUM or LOCAL_QUORUM, which is the default of gocql,
+// so that GetState observes the results of the lightweight transactions.
+type CassandraRepository struct {
+	session *gocql.Session
+	keys    string
+	states  string
+	ttl     time.Duration
+	serial  gocql.SerialConsistency
+}
+
+// CassandraRepositoryOption is an option for CassandraRepository
+type CassandraRepositoryOption func(r *CassandraRepository)
+
+// WithCassandraTables sets the tables of keys and states. The default is atomicop_keys and atomicop_states.
+// The table name can be qualified by keyspace.
+func WithCassandraTables(keys, states string) CassandraRepositoryOption {
+	return func(r *CassandraRepository) {
+		r.keys = keys
+		r.states = states
+	}
+}
+
+// WithCassandraTTL expires rows after ttl from the last update by the TTL of each row.
+// ttl is rounded down to seconds.
+func WithCassandraTTL(ttl time.Duration) CassandraRepositoryOption {
+	return func(r *CassandraRepository) {
+		r.ttl = ttl
+	}
+}
+
+// WithCassandraSerialConsistency sets the serial consistency of the lightweight transactions.
+// The default is gocql.Serial. Use gocql.LocalSerial to keep the transactions in the local datacenter.
+func WithCassandraSerialConsistency(serial gocql.SerialConsistency) CassandraRepositoryOption {
+	return func(r *CassandraRepository) {
+		r.serial = serial
+	}
+}
+
+// NewCassandraRepository creates a CassandraRepository instance
+func NewCassandraRepository(session *gocql.Session, opts ...CassandraRepositoryOption) *CassandraRepository {
+	r := &CassandraRepository{
+		session: session,
+		keys:    "atomicop_keys",
+		states:  "atomicop_states",
+		serial:  gocql.Serial,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// CreateCassandraTables creates the tables of keys and states if not exist.
+func CreateCassandraTables(ctx context.Context, session *gocql.Session, keys, states string) error {
+	queries := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id text PRIMARY KEY)", keys),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id text PRIMARY KEY, state text, version bigint)", states),
+	}
+	for _, q := range queries {
+		if err := session.Query(q).WithContext(ctx).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// query creates a lightweight transaction.
+func (r *CassandraRepository) query(ctx context.Context, stmt string, values ...interface{}) *gocql.Query {
+	return r.session.Query(stmt, values...).WithContext(ctx).SerialConsistency(r.serial)
+}
+
+// seconds returns the TTL of rows. Zero means no TTL.
+func (r *CassandraRepository) seconds() int {
+	return int(r.ttl / time.Second)
+}
+
+// Store stores key by INSERT IF NOT EXISTS.
+// If key is already exists, this method returns duplicateError
+func (r *CassandraRepository) Store(ctx context.Context, key string) error {
+	stmt := fmt.Sprintf("INSERT INTO %s (id) VALUES (?) IF NOT EXISTS USING TTL ?", r.keys)
+	applied, err := r.query(ctx, stmt, key, r.seconds()).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return &duplicateError{errors.New("duplicated")}
+	}
+
+	return nil
+}
+
+// GetState gets state.
+// If key is not found or expired, GetState returns initial state.
+func (r *CassandraRepository) GetState(ctx context.Context, key string) (*State, error) {
+	var encoded string
+	var version int64
+	stmt := fmt.Sprintf("SELECT state, version FROM %s WHERE id = ?", r.states)
+	err := r.session.Query(stmt, key).WithContext(ctx).Scan(&encoded, &version)
+	if err == gocql.ErrNotFound {
+		return &State{Attempts: 0, Value: InitState}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{}
+	if err := json.Unmarshal([]byte(encoded), state); err != nil {
+		return nil, err
+	}
+	state.Version = version
+
+	return state, nil
+}
+
+// UpdateState updates state by compare-and-swap on the current version.
+// The lightweight transaction is retried while the state is updated by others.
+func (r *CassandraRepository) UpdateState(ctx context.Context, key string, state State) error {
+	for {
+		current, err := r.GetState(ctx, key)
+		if err != nil {
+			return err
+		}
+		_, err = r.CompareAndSwapState(ctx, key, current.Version, state)
+		if !conflicted(err) {
+			return err
+		}
+	}
+}
+
+// CompareAndSwapState updates state by UPDATE IF version = ? only if the version of saved state equals version.
+// The state of unknown key is inserted by INSERT IF NOT EXISTS.
+func (r *CassandraRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	state.Version = version + 1
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+
+	var q *gocql.Query
+	if version == 0 {
+		stmt := fmt.Sprintf("INSERT INTO %s (id, state, version) VALUES (?, ?, ?) IF NOT EXISTS USING TTL ?", r.states)
+		q = r.query(ctx, stmt, key, string(encoded), state.Version, r.seconds())
+	} else {
+		stmt := fmt.Sprintf("UPDATE %s USING TTL ? SET state = ?, version = ? WHERE id = ? IF version = ?", r.states)
+		q = r.query(ctx, stmt, r.seconds(), string(encoded), state.Version, key, version)
+	}
+
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return 0, err
+	}
+	if !applied {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d", version)}
+	}
+
+	return state.Version, nil
+}
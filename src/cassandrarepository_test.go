@@ -0,0 +1,86 @@
+package atomicop
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var cassandraTables int32
+
+// newCassandraRepository creates the tables for the test on a local single node.
+func newCassandraRepository(t *testing.T, opts ...CassandraRepositoryOption) *CassandraRepository {
+	cluster := gocql.NewCluster("127.0.0.1:9042")
+	cluster.ConnectTimeout = 5 * time.Second
+	cluster.Timeout = 10 * time.Second
+	session, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("failed to connect to Cassandra: %s", err)
+	}
+	t.Cleanup(session.Close)
+
+	ctx := context.Background()
+	if err := session.Query(`CREATE KEYSPACE IF NOT EXISTS atomicop
+		WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`).WithContext(ctx).Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	n := atomic.AddInt32(&cassandraTables, 1)
+	keys := fmt.Sprintf("atomicop.keys_%d_%d", n, time.Now().Unix())
+	states := fmt.Sprintf("atomicop.states_%d_%d", n, time.Now().Unix())
+	if err := CreateCassandraTables(ctx, session, keys, states); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewCassandraRepository(session, append([]CassandraRepositoryOption{WithCassandraTables(keys, states)}, opts...)...)
+}
+
+func Test_CassandraSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, newCassandraRepository(t))
+}
+
+func Test_CassandraStateRepository(t *testing.T) {
+	t.Parallel()
+
+	testStateRepository(t, newCassandraRepository(t, WithCassandraSerialConsistency(gocql.LocalSerial)))
+}
+
+func Test_CassandraRepository_WithCassandraTTL(t *testing.T) {
+	t.Parallel()
+
+	r := newCassandraRepository(t, WithCassandraTTL(time.Second))
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func prepareCassandra(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := newCassandraRepository(t)
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_Cassandra(t *testing.T) {
+	testRetryableOncer(t, prepareCassandra)
+}
@@ -0,0 +1,197 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	datastoreKeyKind   = "AtomicopKey"
+	datastoreStateKind = "AtomicopState"
+)
+
+// datastoreKey is the entity of a stored key.
+type datastoreKey struct {
+	ExpiresAt time.Time `datastore:"expires_at"`
+}
+
+// datastoreState is the entity of state. State is saved as JSON.
+type datastoreState struct {
+	State     string    `datastore:"state,noindex"`
+	Version   int64     `datastore:"version,noindex"`
+	ExpiresAt time.Time `datastore:"expires_at"`
+}
+
+// DatastoreRepository implements SyncRepository, StateRepository and StateSwapper interface
+// using Firestore in Datastore mode.
+type DatastoreRepository struct {
+	client    *datastore.Client
+	namespace string
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// DatastoreRepositoryOption is an option for DatastoreRepository
+type DatastoreRepositoryOption func(r *DatastoreRepository)
+
+// WithDatastoreNamespace saves entities in namespace.
+func WithDatastoreNamespace(namespace string) DatastoreRepositoryOption {
+	return func(r *DatastoreRepository) {
+		r.namespace = namespace
+	}
+}
+
+// WithDatastoreTTL sets the expiration time of keys and states to "expires_at" property.
+// The expired key is regarded as unknown key.
+// To delete expired entities, configure the TTL policy of Firestore on "expires_at".
+func WithDatastoreTTL(ttl time.Duration) DatastoreRepositoryOption {
+	return func(r *DatastoreRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewDatastoreRepository creates a DatastoreRepository instance
+func NewDatastoreRepository(client *datastore.Client, opts ...DatastoreRepositoryOption) *DatastoreRepository {
+	r := &DatastoreRepository{
+		client: client,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *DatastoreRepository) key(kind, name string) *datastore.Key {
+	k := datastore.NameKey(kind, name, nil)
+	k.Namespace = r.namespace
+	return k
+}
+
+func (r *DatastoreRepository) expiresAt() time.Time {
+	if r.ttl <= 0 {
+		return time.Time{}
+	}
+	return r.now().Add(r.ttl)
+}
+
+func (r *DatastoreRepository) expired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !r.now().Before(expiresAt)
+}
+
+// Store stores key by a transactional create.
+// If key is already exists, this method returns duplicateError
+func (r *DatastoreRepository) Store(ctx context.Context, key string) error {
+	k := r.key(datastoreKeyKind, key)
+	_, err := r.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var current datastoreKey
+		err := tx.Get(k, &current)
+		if err == nil && !r.expired(current.ExpiresAt) {
+			return &duplicateError{errors.New("duplicated")}
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		entity := &datastoreKey{ExpiresAt: r.expiresAt()}
+		if err == nil {
+			// the expired entity is replaced
+			_, err := tx.Put(k, entity)
+			return err
+		}
+		_, err = tx.Mutate(datastore.NewInsert(k, entity))
+		return err
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return &duplicateError{err}
+	}
+
+	return err
+}
+
+// GetState gets state.
+// If key is not found or expired, GetState returns initial state.
+func (r *DatastoreRepository) GetState(ctx context.Context, key string) (*State, error) {
+	var entity datastoreState
+	err := r.client.Get(ctx, r.key(datastoreStateKind, key), &entity)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+
+	return r.decode(&entity, err == nil)
+}
+
+func (r *DatastoreRepository) decode(entity *datastoreState, found bool) (*State, error) {
+	state := &State{Attempts: 0, Value: InitState}
+	if !found || r.expired(entity.ExpiresAt) {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(entity.State), state); err != nil {
+		return nil, err
+	}
+	state.Version = entity.Version
+
+	return state, nil
+}
+
+// UpdateState updates state in a transaction.
+func (r *DatastoreRepository) UpdateState(ctx context.Context, key string, state State) error {
+	_, err := r.swap(ctx, key, func(current int64) error { return nil }, state)
+	return err
+}
+
+// CompareAndSwapState updates state only if the version of saved state equals version.
+func (r *DatastoreRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	return r.swap(ctx, key, func(current int64) error {
+		if current != version {
+			return &conflictError{fmt.Errorf("version mismatch: %d != %d", version, current)}
+		}
+		return nil
+	}, state)
+}
+
+// swap saves state with the next version in a transaction if check is passed.
+func (r *DatastoreRepository) swap(ctx context.Context, key string, check func(current int64) error, state State) (int64, error) {
+	k := r.key(datastoreStateKind, key)
+	var version int64
+	_, err := r.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var entity datastoreState
+		err := tx.Get(k, &entity)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		current, err := r.decode(&entity, err == nil)
+		if err != nil {
+			return err
+		}
+		if err := check(current.Version); err != nil {
+			return err
+		}
+
+		version = current.Version + 1
+		state.Version = version
+		encoded, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Put(k, &datastoreState{
+			State:     string(encoded),
+			Version:   version,
+			ExpiresAt: r.expiresAt(),
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,94 @@
+package atomicop
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+const datastoreEmulatorHost = "127.0.0.1:8081"
+
+// newDatastoreClient connects to the Datastore emulator.
+func newDatastoreClient(t *testing.T) *datastore.Client {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		os.Setenv("DATASTORE_EMULATOR_HOST", datastoreEmulatorHost)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := datastore.NewClient(ctx, "atomicop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// the client connects lazily. check the connection not to wait for the retries of each test.
+	var entity datastoreKey
+	if err := client.Get(ctx, datastore.NameKey(datastoreKeyKind, "ping", nil), &entity); err != nil && err != datastore.ErrNoSuchEntity {
+		t.Fatalf("failed to connect to the emulator: %s", err)
+	}
+
+	return client
+}
+
+// datastoreNamespace isolates the entities of each test.
+func datastoreNamespace(t *testing.T) string {
+	return strings.Replace(t.Name(), "/", "_", -1)
+}
+
+func Test_DatastoreSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, NewDatastoreRepository(newDatastoreClient(t), WithDatastoreNamespace(datastoreNamespace(t))))
+}
+
+func Test_DatastoreStateRepository(t *testing.T) {
+	t.Parallel()
+
+	testStateRepository(t, NewDatastoreRepository(newDatastoreClient(t), WithDatastoreNamespace(datastoreNamespace(t))))
+}
+
+func Test_DatastoreRepository_WithDatastoreTTL(t *testing.T) {
+	t.Parallel()
+
+	r := NewDatastoreRepository(
+		newDatastoreClient(t),
+		WithDatastoreNamespace(datastoreNamespace(t)),
+		WithDatastoreTTL(time.Minute),
+	)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	now = now.Add(time.Minute)
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func prepareDatastore(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := NewDatastoreRepository(newDatastoreClient(t), WithDatastoreNamespace(datastoreNamespace(t)))
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_Datastore(t *testing.T) {
+	testRetryableOncer(t, prepareDatastore)
+}
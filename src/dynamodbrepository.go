@@ -0,0 +1,272 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	dynamoDBPartitionKey = "pk"
+	dynamoDBState        = "state"
+	dynamoDBVersion      = "version"
+	dynamoDBExpiresAt    = "expires_at"
+)
+
+// DynamoDBAPI is the subset of *dynamodb.Client used by DynamoDBRepository.
+type DynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// DynamoDBRepository implements SyncRepository, StateRepository and StateSwapper interface using DynamoDB.
+// Keys and states are saved to a table whose partition key is "pk" of string.
+// The table can be created by CreateDynamoDBTable.
+type DynamoDBRepository struct {
+	client DynamoDBAPI
+	table  string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// DynamoDBRepositoryOption is an option for DynamoDBRepository
+type DynamoDBRepositoryOption func(r *DynamoDBRepository)
+
+// WithDynamoDBTTL sets the expiration time of keys and states to "expires_at" attribute.
+// DynamoDB deletes expired items by TTL in the background,
+// and DynamoDBRepository regards the expired key as unknown key until it is deleted.
+func WithDynamoDBTTL(ttl time.Duration) DynamoDBRepositoryOption {
+	return func(r *DynamoDBRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewDynamoDBRepository creates a DynamoDBRepository instance
+func NewDynamoDBRepository(client DynamoDBAPI, table string, opts ...DynamoDBRepositoryOption) *DynamoDBRepository {
+	r := &DynamoDBRepository{
+		client: client,
+		table:  table,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// CreateDynamoDBTable creates the table for DynamoDBRepository with on-demand capacity,
+// and enables TTL on "expires_at" attribute.
+func CreateDynamoDBTable(ctx context.Context, client *dynamodb.Client, table string) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(dynamoDBPartitionKey), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(dynamoDBPartitionKey), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return err
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, time.Minute); err != nil {
+		return err
+	}
+
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(dynamoDBExpiresAt),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
+
+func dynamoDBConditionFailed(err error) bool {
+	var cerr *types.ConditionalCheckFailedException
+	return errors.As(err, &cerr)
+}
+
+func (r *DynamoDBRepository) syncKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		dynamoDBPartitionKey: &types.AttributeValueMemberS{Value: "sync#" + key},
+	}
+}
+
+func (r *DynamoDBRepository) stateKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		dynamoDBPartitionKey: &types.AttributeValueMemberS{Value: "state#" + key},
+	}
+}
+
+func dynamoDBNumber(n int64) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// expiresAt returns the expiration time in unix seconds which is the format of DynamoDB TTL.
+func (r *DynamoDBRepository) expiresAt() types.AttributeValue {
+	return dynamoDBNumber(r.now().Add(r.ttl).Unix())
+}
+
+// Store stores key using PutItem with the condition that key does not exist or is expired.
+// If key is already exists, this method returns duplicateError
+func (r *DynamoDBRepository) Store(ctx context.Context, key string) error {
+	item := r.syncKey(key)
+	if r.ttl > 0 {
+		item[dynamoDBExpiresAt] = r.expiresAt()
+	}
+
+	_, err := r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(r.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#pk) OR #expires_at <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#pk":         dynamoDBPartitionKey,
+			"#expires_at": dynamoDBExpiresAt,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": dynamoDBNumber(r.now().Unix()),
+		},
+	})
+	if dynamoDBConditionFailed(err) {
+		return &duplicateError{err}
+	}
+
+	return err
+}
+
+// GetState gets state using the strongly consistent read.
+// If key is not found or expired, GetState returns initial state.
+func (r *DynamoDBRepository) GetState(ctx context.Context, key string) (*State, error) {
+	out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(r.table),
+		Key:            r.stateKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{Attempts: 0, Value: InitState}
+	if out.Item == nil {
+		return state, nil
+	}
+	if v, ok := out.Item[dynamoDBExpiresAt].(*types.AttributeValueMemberN); ok {
+		at, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if at <= r.now().Unix() {
+			return state, nil
+		}
+	}
+	if v, ok := out.Item[dynamoDBState].(*types.AttributeValueMemberS); ok {
+		if err := json.Unmarshal([]byte(v.Value), state); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := out.Item[dynamoDBVersion].(*types.AttributeValueMemberN); ok {
+		if state.Version, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+// UpdateState updates state using UpdateItem.
+func (r *DynamoDBRepository) UpdateState(ctx context.Context, key string, state State) error {
+	_, err := r.update(ctx, key, state, "", nil)
+	return err
+}
+
+// CompareAndSwapState updates state only if the version attribute equals version.
+// The version of unknown or expired key is zero.
+func (r *DynamoDBRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	condition := "#version = :version"
+	values := map[string]types.AttributeValue{
+		":version": dynamoDBNumber(version),
+	}
+	if version == 0 {
+		condition = "attribute_not_exists(#version) OR #expires_at <= :now"
+		values = map[string]types.AttributeValue{
+			":now": dynamoDBNumber(r.now().Unix()),
+		}
+	}
+
+	next, err := r.update(ctx, key, state, condition, values)
+	if dynamoDBConditionFailed(err) {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d: %w", version, err)}
+	}
+
+	return next, err
+}
+
+// update saves state and increments the version attribute, then returns the new version.
+func (r *DynamoDBRepository) update(
+	ctx context.Context,
+	key string,
+	state State,
+	condition string,
+	values map[string]types.AttributeValue,
+) (int64, error) {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+
+	expression := "SET #state = :state, #version = if_not_exists(#version, :zero) + :one"
+	names := map[string]string{
+		"#state":   dynamoDBState,
+		"#version": dynamoDBVersion,
+	}
+	if values == nil {
+		values = map[string]types.AttributeValue{}
+	}
+	values[":state"] = &types.AttributeValueMemberS{Value: string(encoded)}
+	values[":zero"] = dynamoDBNumber(0)
+	values[":one"] = dynamoDBNumber(1)
+	if r.ttl > 0 {
+		expression += ", #expires_at = :expires_at"
+		values[":expires_at"] = r.expiresAt()
+	}
+	if r.ttl > 0 || values[":now"] != nil {
+		names["#expires_at"] = dynamoDBExpiresAt
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(r.table),
+		Key:                       r.stateKey(key),
+		UpdateExpression:          aws.String(expression),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueUpdatedNew,
+	}
+	if condition != "" {
+		input.ConditionExpression = aws.String(condition)
+	}
+
+	out, err := r.client.UpdateItem(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+
+	v, ok := out.Attributes[dynamoDBVersion].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("unexpected attributes: %v", out.Attributes)
+	}
+	return strconv.ParseInt(v.Value, 10, 64)
+}
@@ -0,0 +1,126 @@
+package atomicop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const dynamoDBEndpoint = "http://127.0.0.1:8000"
+
+// newDynamoDBTable creates a table for the test on DynamoDB Local.
+func newDynamoDBTable(t *testing.T) (*dynamodb.Client, string) {
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(dynamoDBEndpoint),
+		Credentials:  credentials.NewStaticCredentialsProvider("atomicop", "pass", ""),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	table := strings.Replace(fmt.Sprintf("atomicop-%s-%d", t.Name(), time.Now().UnixNano()), "/", "-", -1)
+	if err := CreateDynamoDBTable(ctx, client, table); err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+	t.Cleanup(func() {
+		client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+
+	return client, table
+}
+
+func Test_DynamoDBSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	client, table := newDynamoDBTable(t)
+	testSyncRepository(t, NewDynamoDBRepository(client, table))
+}
+
+func Test_DynamoDBStateRepository(t *testing.T) {
+	t.Parallel()
+
+	client, table := newDynamoDBTable(t)
+	testStateRepository(t, NewDynamoDBRepository(client, table))
+}
+
+func Test_DynamoDBRepository_WithDynamoDBTTL(t *testing.T) {
+	t.Parallel()
+
+	client, table := newDynamoDBTable(t)
+	r := NewDynamoDBRepository(client, table, WithDynamoDBTTL(time.Minute))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := r.CompareAndSwapState(context.TODO(), "key", 0, State{Attempts: 1, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the expired items are regarded as unknown until they are deleted by DynamoDB.
+	now = now.Add(time.Minute)
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	st, err := r.GetState(context.TODO(), "key")
+	if err != nil || st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+	if _, err := r.CompareAndSwapState(context.TODO(), "key", 0, State{Attempts: 1, Value: RunningState}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func prepareDynamoDB(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	client, table := newDynamoDBTable(t)
+	r := NewDynamoDBRepository(client, table)
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_DynamoDB(t *testing.T) {
+	testRetryableOncer(t, prepareDynamoDB)
+}
+
+func Test_dynamoDBConditionFailed(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"conditional check failed": {
+			err:  &types.ConditionalCheckFailedException{},
+			want: true,
+		},
+		"wrapped": {
+			err:  fmt.Errorf("operation error: %w", &types.ConditionalCheckFailedException{}),
+			want: true,
+		},
+		"other error": {
+			err: errors.New("error"),
+		},
+		"nil": {},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			if got := dynamoDBConditionFailed(tc.err); got != tc.want {
+				t.Errorf("unexpected result: %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdRepository implements SyncRepository, StateRepository and StateSwapper interface using etcd v3.
+// The version of state is the mod revision of the state key.
+type EtcdRepository struct {
+	client *clientv3.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// EtcdRepositoryOption is an option for EtcdRepository
+type EtcdRepositoryOption func(r *EtcdRepository)
+
+// WithEtcdKeyPrefix sets the prefix of keys saved to etcd.
+func WithEtcdKeyPrefix(prefix string) EtcdRepositoryOption {
+	return func(r *EtcdRepository) {
+		r.prefix = prefix
+	}
+}
+
+// WithEtcdLease attaches a lease of ttl to keys and states on every write.
+// The key whose lease is expired is regarded as unknown key.
+// ttl is rounded up to seconds, and a lease is granted by every write.
+func WithEtcdLease(ttl time.Duration) EtcdRepositoryOption {
+	return func(r *EtcdRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewEtcdRepository creates an EtcdRepository instance
+func NewEtcdRepository(client *clientv3.Client, opts ...EtcdRepositoryOption) *EtcdRepository {
+	r := &EtcdRepository{
+		client: client,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *EtcdRepository) syncKey(key string) string {
+	return r.prefix + "sync/" + key
+}
+
+func (r *EtcdRepository) stateKey(key string) string {
+	return r.prefix + "state/" + key
+}
+
+// putOptions grants a lease if it is enabled.
+func (r *EtcdRepository) putOptions(ctx context.Context) ([]clientv3.OpOption, error) {
+	if r.ttl <= 0 {
+		return nil, nil
+	}
+
+	seconds := int64((r.ttl + time.Second - 1) / time.Second)
+	lease, err := r.client.Grant(ctx, seconds)
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
+
+// Store stores key if the key is not created yet.
+// If key is already exists, this method returns duplicateError
+func (r *EtcdRepository) Store(ctx context.Context, key string) error {
+	opts, err := r.putOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	k := r.syncKey(key)
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(k), "=", 0)).
+		Then(clientv3.OpPut(k, "", opts...)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return &duplicateError{errors.New("duplicated")}
+	}
+
+	return nil
+}
+
+// Exists reports whether key is stored.
+func (r *EtcdRepository) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := r.client.Get(ctx, r.syncKey(key), clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Count > 0, nil
+}
+
+// GetState gets state using etcd.
+// If key is not found or expired, GetState returns initial state.
+func (r *EtcdRepository) GetState(ctx context.Context, key string) (*State, error) {
+	resp, err := r.client.Get(ctx, r.stateKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{Attempts: 0, Value: InitState}
+	if len(resp.Kvs) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(resp.Kvs[0].Value, state); err != nil {
+		return nil, err
+	}
+	state.Version = resp.Kvs[0].ModRevision
+
+	return state, nil
+}
+
+// UpdateState updates state using etcd.
+func (r *EtcdRepository) UpdateState(ctx context.Context, key string, state State) error {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	opts, err := r.putOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Put(ctx, r.stateKey(key), string(encoded), opts...)
+	return err
+}
+
+// CompareAndSwapState updates state only if the mod revision of saved state equals version.
+func (r *EtcdRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+	opts, err := r.putOptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	k := r.stateKey(key)
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(k), "=", version)).
+		Then(clientv3.OpPut(k, string(encoded), opts...)).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d", version)}
+	}
+
+	return resp.Header.Revision, nil
+}
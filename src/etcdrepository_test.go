@@ -0,0 +1,120 @@
+package atomicop
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+var (
+	etcdOnce     sync.Once
+	etcdEndpoint string
+	etcdErr      error
+)
+
+// startEtcd starts an embedded etcd server which is shared by the tests.
+func startEtcd(t *testing.T) string {
+	etcdOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "atomicop-etcd")
+		if err != nil {
+			etcdErr = err
+			return
+		}
+
+		cfg := embed.NewConfig()
+		cfg.Dir = dir
+		cfg.LogLevel = "error"
+		clientURL, _ := url.Parse("http://127.0.0.1:0")
+		peerURL, _ := url.Parse("http://127.0.0.1:0")
+		cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+		cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+		cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+		e, err := embed.StartEtcd(cfg)
+		if err != nil {
+			etcdErr = err
+			return
+		}
+		select {
+		case <-e.Server.ReadyNotify():
+			etcdEndpoint = e.Clients[0].Addr().String()
+		case <-time.After(30 * time.Second):
+			e.Close()
+			etcdErr = context.DeadlineExceeded
+		}
+	})
+	if etcdErr != nil {
+		t.Fatalf("failed to start etcd: %s", etcdErr)
+	}
+
+	return etcdEndpoint
+}
+
+func newEtcdClient(t *testing.T) *clientv3.Client {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{startEtcd(t)},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func Test_EtcdSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, NewEtcdRepository(newEtcdClient(t), WithEtcdKeyPrefix(t.Name()+"/")))
+}
+
+func Test_EtcdStateRepository(t *testing.T) {
+	t.Parallel()
+
+	testStateRepository(t, NewEtcdRepository(newEtcdClient(t), WithEtcdKeyPrefix(t.Name()+"/")))
+}
+
+func Test_EtcdRepository_WithEtcdLease(t *testing.T) {
+	t.Parallel()
+
+	client := newEtcdClient(t)
+	r := NewEtcdRepository(client, WithEtcdKeyPrefix(t.Name()+"/"), WithEtcdLease(time.Second))
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	resp, err := client.Get(context.TODO(), t.Name()+"/sync/key")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(resp.Kvs) != 1 || resp.Kvs[0].Lease == 0 {
+		t.Fatalf("unexpected kvs: %v", resp.Kvs)
+	}
+
+	// expire the key instead of waiting for the lease
+	if _, err := client.Revoke(context.TODO(), clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func prepareEtcd(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := NewEtcdRepository(newEtcdClient(t), WithEtcdKeyPrefix(t.Name()+"/"))
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_Etcd(t *testing.T) {
+	testRetryableOncer(t, prepareEtcd)
+}
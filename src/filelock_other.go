@@ -0,0 +1,25 @@
+//go:build !unix
+
+package atomicop
+
+import (
+	"os"
+	"time"
+)
+
+// lockFile locks path by creating it exclusively, and returns the function to unlock.
+// Unlike flock, the lock is left if the process crashes while locking,
+// then the lock file must be removed by hand.
+func lockFile(path string) (func() error, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package atomicop
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks path exclusively by flock, and returns the function to unlock.
+// The lock is released by the kernel even if the process crashes.
+func lockFile(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer f.Close()
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}, nil
+}
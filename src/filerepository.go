@@ -0,0 +1,326 @@
+package atomicop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileRepository implements SyncRepository, StateRepository and StateSwapper interface using files on a directory.
+// The operations are atomic on between processes on one host which share the directory.
+// Each key is saved as a file named by the hash of the key in a sharded directory.
+// A key is stored by the exclusive creation of the file,
+// and state is replaced by the atomic rename while the shard is locked.
+type FileRepository struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+}
+
+// FileRepositoryOption is an option for FileRepository
+type FileRepositoryOption func(r *FileRepository)
+
+// WithFileTTL expires keys and states after ttl from the last update.
+// The expired key is regarded as unknown key, and its file is removed by Cleanup.
+func WithFileTTL(ttl time.Duration) FileRepositoryOption {
+	return func(r *FileRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewFileRepository creates a FileRepository instance on dir.
+// dir is created if not exists.
+func NewFileRepository(dir string, opts ...FileRepositoryOption) (*FileRepository, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	r := &FileRepository{
+		dir: dir,
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r, nil
+}
+
+const (
+	fileSyncKind  = "sync"
+	fileStateKind = "state"
+	fileLockName  = ".lock"
+)
+
+// path returns the shard directory and the file path of key.
+func (r *FileRepository) path(kind, key string) (string, string) {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	shard := filepath.Join(r.dir, kind, name[:2])
+	return shard, filepath.Join(shard, name)
+}
+
+// lock locks the shard for the other goroutines and processes.
+func (r *FileRepository) lock(shard string) (func() error, error) {
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		return nil, err
+	}
+	return lockFile(filepath.Join(shard, fileLockName))
+}
+
+func (r *FileRepository) expired(info os.FileInfo) bool {
+	return r.ttl > 0 && !r.now().Before(info.ModTime().Add(r.ttl))
+}
+
+// Store stores key by creating the file exclusively.
+// If key is already exists, this method returns duplicateError
+func (r *FileRepository) Store(ctx context.Context, key string) error {
+	shard, path := r.path(fileSyncKind, key)
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		return err
+	}
+
+	err := r.create(path, key)
+	if !os.IsExist(err) {
+		return err
+	}
+	if r.ttl <= 0 {
+		return &duplicateError{err}
+	}
+
+	// the key may be expired. it is replaced under the lock not to remove the key created by others.
+	unlock, err := r.lock(shard)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if !r.expired(info) {
+			return &duplicateError{errors.New("duplicated")}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := r.create(path, key); os.IsExist(err) {
+		return &duplicateError{err}
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Exists reports whether key is stored and not expired.
+func (r *FileRepository) Exists(ctx context.Context, key string) (bool, error) {
+	_, path := r.path(fileSyncKind, key)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !r.expired(info), nil
+}
+
+func (r *FileRepository) create(path, key string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	// the key is written for debugging because the file name is hashed.
+	if _, err := f.WriteString(key); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// the modification time is used for the expiration.
+	now := r.now()
+	return os.Chtimes(path, now, now)
+}
+
+// GetState gets state from the file.
+// If key is not found or expired, GetState returns initial state.
+func (r *FileRepository) GetState(ctx context.Context, key string) (*State, error) {
+	_, path := r.path(fileStateKind, key)
+	return r.load(path)
+}
+
+func (r *FileRepository) load(path string) (*State, error) {
+	state := &State{Attempts: 0, Value: InitState}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if r.expired(info) {
+		return state, nil
+	}
+	if err := json.NewDecoder(f).Decode(state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// UpdateState updates state by replacing the file.
+func (r *FileRepository) UpdateState(ctx context.Context, key string, state State) error {
+	_, err := r.swap(key, func(current int64) error { return nil }, state)
+	return err
+}
+
+// CompareAndSwapState updates state only if the version of saved state equals version.
+func (r *FileRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	return r.swap(key, func(current int64) error {
+		if current != version {
+			return &conflictError{fmt.Errorf("version mismatch: %d != %d", version, current)}
+		}
+		return nil
+	}, state)
+}
+
+// swap replaces state with the next version if check is passed.
+func (r *FileRepository) swap(key string, check func(current int64) error, state State) (version int64, err error) {
+	shard, path := r.path(fileStateKind, key)
+	unlock, err := r.lock(shard)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	current, err := r.load(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := check(current.Version); err != nil {
+		return 0, err
+	}
+	state.Version = current.Version + 1
+
+	f, err := os.CreateTemp(shard, ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := json.NewEncoder(f).Encode(state); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	now := r.now()
+	if err := os.Chtimes(f.Name(), now, now); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return 0, err
+	}
+
+	return state.Version, nil
+}
+
+// Cleanup removes the files of expired keys and states, and returns the number of removed files.
+// Cleanup does nothing if the ttl is not set.
+func (r *FileRepository) Cleanup(ctx context.Context) (int, error) {
+	if r.ttl <= 0 {
+		return 0, nil
+	}
+
+	removed := 0
+	for _, kind := range []string{fileSyncKind, fileStateKind} {
+		shards, err := os.ReadDir(filepath.Join(r.dir, kind))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+
+		for _, shard := range shards {
+			if err := ctx.Err(); err != nil {
+				return removed, err
+			}
+			n, err := r.cleanupShard(filepath.Join(r.dir, kind, shard.Name()))
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+func (r *FileRepository) cleanupShard(shard string) (int, error) {
+	unlock, err := r.lock(shard)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	entries, err := os.ReadDir(shard)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		// the lock file is kept. the temporary files left by crashes are removed as well.
+		if entry.Name() == fileLockName {
+			continue
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if !r.expired(info) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(shard, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
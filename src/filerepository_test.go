@@ -0,0 +1,95 @@
+package atomicop
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newFileRepository(t *testing.T, opts ...FileRepositoryOption) *FileRepository {
+	r, err := NewFileRepository(t.TempDir(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func Test_FileSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, newFileRepository(t))
+}
+
+func Test_FileStateRepository(t *testing.T) {
+	t.Parallel()
+
+	testStateRepository(t, newFileRepository(t))
+}
+
+func Test_FileRepository_path(t *testing.T) {
+	t.Parallel()
+
+	r := newFileRepository(t)
+	if err := r.Store(context.TODO(), "../../escaped/key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	shard, path := r.path(fileSyncKind, "../../escaped/key")
+	if filepath.Dir(path) != shard || filepath.Dir(filepath.Dir(shard)) != r.dir {
+		t.Errorf("unexpected path: %s", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "../../escaped/key" {
+		t.Errorf("unexpected file: %s, %v", b, err)
+	}
+}
+
+func Test_FileRepository_WithFileTTL(t *testing.T) {
+	t.Parallel()
+
+	r := newFileRepository(t, WithFileTTL(time.Minute))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.Store(context.TODO(), "another"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	now = now.Add(time.Minute)
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+
+	// "key" is stored again just now, "another" and the state are expired.
+	n, err := r.Cleanup(context.TODO())
+	if err != nil || n != 2 {
+		t.Errorf("unexpected result: %d, %v", n, err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func prepareFile(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := newFileRepository(t)
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_File(t *testing.T) {
+	testRetryableOncer(t, prepareFile)
+}
@@ -0,0 +1,68 @@
+package atomicop
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// heartbeat renews the heartbeat of running state in background.
+type heartbeat struct {
+	once    sync.Once
+	quit    chan struct{}
+	done    chan struct{}
+	version int64
+}
+
+// stop stops the renewal and waits for the last renewal.
+// Then the version of state is renewed by the version of the last renewal.
+// The last renewal is not cancelled because it may be applied even if the request is cancelled.
+// stop is safe to call on nil and to call more than once.
+func (h *heartbeat) stop(state *State) {
+	if h == nil {
+		return
+	}
+	h.once.Do(func() { close(h.quit) })
+	<-h.done
+	state.Version = h.version
+}
+
+// run saves state as running state and starts heartbeat if the attempt timeout is set.
+// The heartbeat is stopped if the key is cancelled or updated by another.
+func (r *RetryableOncer) run(ctx context.Context, key string, state *State) (*heartbeat, error) {
+	if r.attemptTimeout <= 0 {
+		return nil, nil
+	}
+
+	state.Value = RunningState
+	state.Owner = r.owner
+	state.Heartbeat = r.now()
+	if err := r.update(ctx, key, state); err != nil {
+		return nil, err
+	}
+
+	h := &heartbeat{quit: make(chan struct{}), done: make(chan struct{}), version: state.Version}
+	go func(state State) {
+		defer close(h.done)
+
+		ticker := time.NewTicker(r.heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.quit:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				state.Heartbeat = r.now()
+				err := r.update(ctx, key, &state)
+				if cancelled(err) || conflicted(err) {
+					return
+				}
+				h.version = state.Version
+			}
+		}
+	}(*state)
+
+	return h, nil
+}
@@ -0,0 +1,124 @@
+package atomicop
+
+import (
+	"container/list"
+	"time"
+)
+
+// lru is a bounded set of keys ordered by recency.
+// lru is not safe for concurrent use.
+type lru struct {
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int
+
+	evictions   uint64
+	expirations uint64
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+func newLRU(maxEntries, maxBytes int, ttl time.Duration) *lru {
+	return &lru{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+// get gets the value of key.
+// If touch is true, key becomes the most recent.
+func (c *lru) get(key string, now time.Time, touch bool) (interface{}, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	ent := e.Value.(*lruEntry)
+	if c.expired(ent, now) {
+		c.remove(e)
+		c.expirations++
+		return nil, false
+	}
+	if touch {
+		c.ll.MoveToFront(e)
+	}
+
+	return ent.value, true
+}
+
+// add adds key as the most recent, then evicts the oldest keys over the limits.
+func (c *lru) add(key string, value interface{}, now time.Time) {
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = now.Add(c.ttl)
+	}
+	c.put(key, value, expiresAt, now)
+}
+
+// put is same as add except that key is expired at expiresAt.
+func (c *lru) put(key string, value interface{}, expiresAt, now time.Time) {
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+
+	ent := &lruEntry{key: key, value: value, expiresAt: expiresAt}
+	c.items[key] = c.ll.PushFront(ent)
+	c.bytes += len(key)
+
+	for e := c.ll.Back(); e != nil && c.expired(e.Value.(*lruEntry), now); e = c.ll.Back() {
+		c.remove(e)
+		c.expirations++
+	}
+	for c.over() {
+		c.remove(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// delete deletes key.
+func (c *lru) delete(key string) {
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+}
+
+// each calls fn for each keys from the oldest.
+func (c *lru) each(now time.Time, fn func(ent *lruEntry) bool) {
+	for e := c.ll.Back(); e != nil; e = e.Prev() {
+		ent := e.Value.(*lruEntry)
+		if !c.expired(ent, now) && !fn(ent) {
+			return
+		}
+	}
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
+
+func (c *lru) over() bool {
+	if c.ll.Len() <= 1 {
+		return false
+	}
+	return (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+func (c *lru) expired(ent *lruEntry, now time.Time) bool {
+	return !ent.expiresAt.IsZero() && !now.Before(ent.expiresAt)
+}
+
+func (c *lru) remove(e *list.Element) {
+	ent := c.ll.Remove(e).(*lruEntry)
+	delete(c.items, ent.key)
+	c.bytes -= len(ent.key)
+}
@@ -0,0 +1,102 @@
+package atomicop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// memcachedMaxRelativeExpiration is the longest expiration which memcached treats as relative seconds.
+// The longer expiration is sent as the unix time.
+const memcachedMaxRelativeExpiration = 30 * 24 * time.Hour
+
+// MemcachedRepository implements SyncRepository interface using the add command of memcached.
+//
+// Memcached is a cache. A stored key can be evicted by LRU before its TTL when memcached runs out of memory,
+// or lost when the server restarts or leaves the pool, then the operation of the key can be executed again.
+// Use it for the best-effort deduplication only.
+type MemcachedRepository struct {
+	client *memcache.Client
+	prefix string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// MemcachedRepositoryOption is an option for MemcachedRepository
+type MemcachedRepositoryOption func(r *MemcachedRepository)
+
+// WithMemcachedKeyPrefix sets the prefix of keys saved to memcached.
+func WithMemcachedKeyPrefix(prefix string) MemcachedRepositoryOption {
+	return func(r *MemcachedRepository) {
+		r.prefix = prefix
+	}
+}
+
+// WithMemcachedTTL expires keys after ttl. ttl is rounded down to seconds.
+// Note that the key can be evicted before ttl.
+func WithMemcachedTTL(ttl time.Duration) MemcachedRepositoryOption {
+	return func(r *MemcachedRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewMemcachedRepository creates a MemcachedRepository instance
+func NewMemcachedRepository(client *memcache.Client, opts ...MemcachedRepositoryOption) *MemcachedRepository {
+	r := &MemcachedRepository{
+		client: client,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// key returns the key of memcached.
+// The key which is too long or contains spaces or control characters is replaced by its hash.
+func (r *MemcachedRepository) key(key string) string {
+	k := r.prefix + key
+	if len(k) > 250 {
+		return r.hashed(key)
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] == 0x7f {
+			return r.hashed(key)
+		}
+	}
+	return k
+}
+
+func (r *MemcachedRepository) hashed(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return r.prefix + "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (r *MemcachedRepository) expiration() int32 {
+	if r.ttl <= 0 {
+		return 0
+	}
+	if r.ttl > memcachedMaxRelativeExpiration {
+		return int32(r.now().Add(r.ttl).Unix())
+	}
+	return int32(r.ttl / time.Second)
+}
+
+// Store stores key by the add command.
+// If key is already exists, this method returns duplicateError
+func (r *MemcachedRepository) Store(ctx context.Context, key string) error {
+	err := r.client.Add(&memcache.Item{
+		Key:        r.key(key),
+		Value:      []byte{'1'},
+		Expiration: r.expiration(),
+	})
+	if err == memcache.ErrNotStored {
+		return &duplicateError{err}
+	}
+
+	return err
+}
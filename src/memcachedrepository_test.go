@@ -0,0 +1,188 @@
+package atomicop
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// memcachedServer is an in-process stand-in of memcached which speaks the add command of the text protocol.
+type memcachedServer struct {
+	mu    sync.Mutex
+	items map[string]time.Time // key -> expiration. zero means no expiration.
+	now   time.Time
+}
+
+func newMemcachedServer(t *testing.T) (*memcachedServer, *memcache.Client) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &memcachedServer{items: map[string]time.Time{}, now: time.Now()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, memcache.New(l.Addr().String())
+}
+
+func (s *memcachedServer) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(d)
+}
+
+func (s *memcachedServer) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.items))
+	for k := range s.items {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// exists reports whether key exists and is not expired. s.mu must be held.
+func (s *memcachedServer) exists(key string) bool {
+	exp, ok := s.items[key]
+	return ok && (exp.IsZero() || s.now.Before(exp))
+}
+
+func (s *memcachedServer) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "add":
+			// add <key> <flags> <exptime> <bytes>
+			size, _ := strconv.Atoi(fields[4])
+			if _, err := io.CopyN(io.Discard, rw, int64(size)+2); err != nil {
+				return
+			}
+			exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+			s.mu.Lock()
+			if s.exists(fields[1]) {
+				fmt.Fprint(rw, "NOT_STORED\r\n")
+			} else {
+				var exp time.Time
+				switch {
+				case exptime > int64(memcachedMaxRelativeExpiration/time.Second):
+					exp = time.Unix(exptime, 0)
+				case exptime > 0:
+					exp = s.now.Add(time.Duration(exptime) * time.Second)
+				}
+				s.items[fields[1]] = exp
+				fmt.Fprint(rw, "STORED\r\n")
+			}
+			s.mu.Unlock()
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func Test_MemcachedSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	_, client := newMemcachedServer(t)
+	testSyncRepository(t, NewMemcachedRepository(client))
+}
+
+func Test_MemcachedRepository_WithMemcachedTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		ttl time.Duration
+	}{
+		"relative": {ttl: time.Minute},
+		"absolute": {ttl: 31 * 24 * time.Hour},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, client := newMemcachedServer(t)
+			r := NewMemcachedRepository(client, WithMemcachedKeyPrefix("app:"), WithMemcachedTTL(tt.ttl))
+			r.now = func() time.Time { return s.now }
+
+			if err := r.Store(context.TODO(), "key"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if keys := s.Keys(); len(keys) != 1 || keys[0] != "app:key" {
+				t.Errorf("unexpected keys: %v", keys)
+			}
+
+			s.FastForward(tt.ttl)
+
+			if err := r.Store(context.TODO(), "key"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func Test_MemcachedRepository_key(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		key string
+	}{
+		"space":   {key: "a key"},
+		"newline": {key: "key\r\n"},
+		"long":    {key: strings.Repeat("k", 251)},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, client := newMemcachedServer(t)
+			r := NewMemcachedRepository(client)
+
+			if err := r.Store(context.TODO(), tt.key); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := r.Store(context.TODO(), tt.key); !duplicated(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if keys := s.Keys(); len(keys) != 1 || !strings.HasPrefix(keys[0], "sha256:") {
+				t.Errorf("unexpected keys: %v", keys)
+			}
+		})
+	}
+}
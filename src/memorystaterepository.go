@@ -0,0 +1,174 @@
+package atomicop
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MemoryStateRepository implements StateRepository and StateSwapper interface on memory.
+// MemoryStateRepository is safe for concurrent use.
+type MemoryStateRepository struct {
+	mu        sync.Mutex
+	states    map[string]memoryState
+	ttl       time.Duration
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+type memoryState struct {
+	state     State
+	expiresAt time.Time
+}
+
+// MemoryStateRepositoryOption is an option for MemoryStateRepository
+type MemoryStateRepositoryOption func(r *MemoryStateRepository)
+
+// WithStateTTL expires state after ttl from the last update.
+// The expired key is regarded as unknown key.
+func WithStateTTL(ttl time.Duration) MemoryStateRepositoryOption {
+	return func(r *MemoryStateRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewMemoryStateRepository creates a MemoryStateRepository instance
+func NewMemoryStateRepository(opts ...MemoryStateRepositoryOption) *MemoryStateRepository {
+	r := &MemoryStateRepository{
+		states: map[string]memoryState{},
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// GetState gets state.
+// If key is not found or expired, GetState returns initial state.
+func (r *MemoryStateRepository) GetState(ctx context.Context, key string) (*State, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := r.load(key)
+	return &state, nil
+}
+
+// UpdateState updates state.
+func (r *MemoryStateRepository) UpdateState(ctx context.Context, key string, state State) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.store(key, r.load(key).Version+1, state)
+	return nil
+}
+
+// CompareAndSwapState updates state only if the version of saved state equals version.
+func (r *MemoryStateRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.load(key).Version
+	if current != version {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d != %d", version, current)}
+	}
+
+	r.store(key, version+1, state)
+	return version + 1, nil
+}
+
+// Snapshot writes all states to w.
+func (r *MemoryStateRepository) Snapshot(w io.Writer) error {
+	r.mu.Lock()
+	states := make(map[string]memoryState, len(r.states))
+	for k, v := range r.states {
+		if !r.expired(v) {
+			states[k] = v
+		}
+	}
+	r.mu.Unlock()
+
+	sw, err := newSnapshotWriter(w, stateSnapshot)
+	if err != nil {
+		return err
+	}
+	for k, v := range states {
+		state := v.state
+		if err := sw.write(k, &state, v.expiresAt); err != nil {
+			return err
+		}
+	}
+
+	return sw.flush()
+}
+
+// Restore adds states from the snapshot of rd.
+// The states of the same keys are overwritten, and the states which are already expired are skipped.
+func (r *MemoryStateRepository) Restore(rd io.Reader) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return readSnapshot(rd, stateSnapshot, func(e snapshotEntry) error {
+		v := memoryState{state: *e.State, expiresAt: e.expiresAt()}
+		if !r.expired(v) {
+			r.states[e.Key] = v
+		}
+		return nil
+	})
+}
+
+// load loads state of key. r.mu must be held.
+func (r *MemoryStateRepository) load(key string) State {
+	v, ok := r.states[key]
+	if !ok || r.expired(v) {
+		return State{Attempts: 0, Value: InitState}
+	}
+
+	return copyState(v.state)
+}
+
+// store stores state of key with version. r.mu must be held.
+func (r *MemoryStateRepository) store(key string, version int64, state State) {
+	now := r.now()
+	state = copyState(state)
+	state.Version = version
+	v := memoryState{state: state}
+	if r.ttl > 0 {
+		v.expiresAt = now.Add(r.ttl)
+		r.sweep(now)
+	}
+	r.states[key] = v
+}
+
+func (r *MemoryStateRepository) expired(v memoryState) bool {
+	return !v.expiresAt.IsZero() && !r.now().Before(v.expiresAt)
+}
+
+// sweep deletes expired states at most once in ttl. r.mu must be held.
+func (r *MemoryStateRepository) sweep(now time.Time) {
+	if now.Before(r.nextSweep) {
+		return
+	}
+	for k, v := range r.states {
+		if r.expired(v) {
+			delete(r.states, k)
+		}
+	}
+	r.nextSweep = now.Add(r.ttl)
+}
+
+// copyState copies state not to share ClassAttempts.
+func copyState(state State) State {
+	if state.ClassAttempts != nil {
+		counts := make(map[string]int, len(state.ClassAttempts))
+		for k, v := range state.ClassAttempts {
+			counts[k] = v
+		}
+		state.ClassAttempts = counts
+	}
+
+	return state
+}
@@ -0,0 +1,112 @@
+package atomicop
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_MemoryStateRepository(t *testing.T) {
+	t.Parallel()
+
+	r := NewMemoryStateRepository()
+
+	st, err := r.GetState(context.TODO(), "key")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if st.Value != InitState || st.Attempts != 0 || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+
+	classAttempts := map[string]int{"timeout": 1}
+	if err := r.UpdateState(context.TODO(), "key", State{
+		Attempts:      1,
+		Value:         RetryState,
+		ClassAttempts: classAttempts,
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	classAttempts["timeout"] = 2
+
+	st, _ = r.GetState(context.TODO(), "key")
+	if st.Value != RetryState || st.Attempts != 1 || st.Version != 1 || st.ClassAttempts["timeout"] != 1 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_MemoryStateRepository_CompareAndSwapState(t *testing.T) {
+	t.Parallel()
+
+	r := NewMemoryStateRepository()
+
+	version, err := r.CompareAndSwapState(context.TODO(), "key", 0, State{Attempts: 1, Value: RunningState})
+	if err != nil || version != 1 {
+		t.Fatalf("unexpected result: %d, %v", version, err)
+	}
+
+	_, err = r.CompareAndSwapState(context.TODO(), "key", 0, State{Attempts: 1, Value: DoneState})
+	if !conflicted(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	version, err = r.CompareAndSwapState(context.TODO(), "key", version, State{Attempts: 1, Value: DoneState})
+	if err != nil || version != 2 {
+		t.Fatalf("unexpected result: %d, %v", version, err)
+	}
+
+	st, _ := r.GetState(context.TODO(), "key")
+	if st.Value != DoneState || st.Version != 2 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_MemoryStateRepository_WithStateTTL(t *testing.T) {
+	t.Parallel()
+
+	r := NewMemoryStateRepository(WithStateTTL(time.Minute))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: DoneState})
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != DoneState {
+		t.Errorf("unexpected state: %+v", st)
+	}
+
+	now = now.Add(time.Minute)
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+
+	// expired states are swept by update
+	r.UpdateState(context.TODO(), "another", State{Attempts: 1, Value: DoneState})
+	if len(r.states) != 1 {
+		t.Errorf("unexpected states: %d", len(r.states))
+	}
+}
+
+func Test_MemoryStateRepository_with_RetryableOncer(t *testing.T) {
+	t.Parallel()
+
+	retryableOncer := NewRetryableOncer(5, NewOnce(NewSyncMapRepository()), NewMemoryStateRepository())
+
+	var counter int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+				atomic.AddInt32(&counter, 1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if counter != 1 {
+		t.Errorf("unexpected execution times: %d", counter)
+	}
+}
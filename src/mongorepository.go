@@ -0,0 +1,174 @@
+package atomicop
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	mongoKeysCollection   = "atomicop_keys"
+	mongoStatesCollection = "atomicop_states"
+)
+
+func mongoErrorConvert(err error) error {
+	if serr, ok := err.(mongo.ServerError); ok {
+		// duplicate key
+		if serr.HasErrorCode(11000) {
+			return &duplicateError{serr}
+		}
+		return err
+	}
+
+	return err
+}
+
+// mongoStateFields are the fields of state document which are set by every update.
+type mongoStateFields struct {
+	Value         StateValue     `bson:"state"`
+	Attempts      int            `bson:"attempts"`
+	Limit         int            `bson:"max_attempts"`
+	Deadline      time.Time      `bson:"deadline"`
+	ClassAttempts map[string]int `bson:"class_attempts,omitempty"`
+	RetryAt       time.Time      `bson:"retry_at"`
+	Owner         string         `bson:"owner"`
+	Heartbeat     time.Time      `bson:"heartbeat"`
+	Reason        string         `bson:"reason"`
+}
+
+type mongoStateDocument struct {
+	mongoStateFields `bson:",inline"`
+	Version          int64 `bson:"version"`
+}
+
+// SetupMongoDB creates the TTL indexes on "createdAt" of the collections used by MongoRepository.
+// Documents are deleted by MongoDB in background after ttl from the creation.
+// Until a document is deleted, its key is regarded as existing.
+// If ttl is zero, SetupMongoDB does nothing.
+func SetupMongoDB(ctx context.Context, db *mongo.Database, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	for _, name := range []string{mongoKeysCollection, mongoStatesCollection} {
+		_, err := db.Collection(name).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(ttl / time.Second)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MongoRepository implements SyncRepository, StateRepository and StateSwapper interface using MongoDB.
+// Keys and states are saved to the collections of db. The collections can be set up by SetupMongoDB.
+type MongoRepository struct {
+	keys   *mongo.Collection
+	states *mongo.Collection
+	now    func() time.Time
+}
+
+// NewMongoRepository creates a MongoRepository instance
+func NewMongoRepository(db *mongo.Database) *MongoRepository {
+	return &MongoRepository{
+		keys:   db.Collection(mongoKeysCollection),
+		states: db.Collection(mongoStatesCollection),
+		now:    time.Now,
+	}
+}
+
+// Store stores key by inserting {_id: key}.
+// If key is already exists, this method returns duplicateError
+func (r *MongoRepository) Store(ctx context.Context, key string) error {
+	_, err := r.keys.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: key},
+		{Key: "createdAt", Value: r.now()},
+	})
+	return mongoErrorConvert(err)
+}
+
+// GetState gets state.
+// If key is not found, GetState returns initial state.
+func (r *MongoRepository) GetState(ctx context.Context, key string) (*State, error) {
+	var doc mongoStateDocument
+	err := r.states.FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return &State{Attempts: 0, Value: InitState}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.state(), nil
+}
+
+func (doc *mongoStateDocument) state() *State {
+	return &State{
+		Value:         doc.Value,
+		Attempts:      doc.Attempts,
+		Limit:         doc.Limit,
+		Deadline:      doc.Deadline,
+		ClassAttempts: doc.ClassAttempts,
+		RetryAt:       doc.RetryAt,
+		Owner:         doc.Owner,
+		Heartbeat:     doc.Heartbeat,
+		Reason:        doc.Reason,
+		Version:       doc.Version,
+	}
+}
+
+// update returns the update document which sets state and increments the version.
+func (r *MongoRepository) update(state State) bson.D {
+	return bson.D{
+		{Key: "$set", Value: mongoStateFields{
+			Value:         state.Value,
+			Attempts:      state.Attempts,
+			Limit:         state.Limit,
+			Deadline:      state.Deadline,
+			ClassAttempts: state.ClassAttempts,
+			RetryAt:       state.RetryAt,
+			Owner:         state.Owner,
+			Heartbeat:     state.Heartbeat,
+			Reason:        state.Reason,
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "createdAt", Value: r.now()}}},
+	}
+}
+
+// UpdateState updates state.
+func (r *MongoRepository) UpdateState(ctx context.Context, key string, state State) error {
+	_, err := r.states.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: key}},
+		r.update(state),
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// CompareAndSwapState updates state by findOneAndUpdate only if the version of saved state equals version.
+// If the version is mismatched, the filter does not match any document.
+// For version zero, the upsert fails by the duplicate key of the existing document instead.
+func (r *MongoRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	filter := bson.D{{Key: "_id", Value: key}, {Key: "version", Value: version}}
+	opts := options.FindOneAndUpdate().SetUpsert(version == 0).SetReturnDocument(options.After)
+
+	var doc mongoStateDocument
+	err := r.states.FindOneAndUpdate(ctx, filter, r.update(state), opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments || mongo.IsDuplicateKeyError(err) {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d", version)}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return doc.Version, nil
+}
@@ -0,0 +1,116 @@
+package atomicop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const mongoURI = "mongodb://127.0.0.1:27017"
+
+var mongoDatabases int32
+
+// newMongoDatabase connects to MongoDB and returns the database for the test.
+func newMongoDatabase(t *testing.T) *mongo.Database {
+	client, err := mongo.Connect(options.Client().ApplyURI(mongoURI).SetServerSelectionTimeout(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	if err := client.Ping(context.TODO(), nil); err != nil {
+		t.Fatalf("failed to connect to MongoDB: %s", err)
+	}
+
+	name := fmt.Sprintf("atomicop_%d_%d", atomic.AddInt32(&mongoDatabases, 1), time.Now().UnixNano())
+	db := client.Database(name)
+	t.Cleanup(func() { db.Drop(context.Background()) })
+
+	return db
+}
+
+func Test_MongoSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, NewMongoRepository(newMongoDatabase(t)))
+}
+
+func Test_MongoStateRepository(t *testing.T) {
+	t.Parallel()
+
+	testStateRepository(t, NewMongoRepository(newMongoDatabase(t)))
+}
+
+func Test_SetupMongoDB(t *testing.T) {
+	t.Parallel()
+
+	db := newMongoDatabase(t)
+	if err := SetupMongoDB(context.TODO(), db, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cursor, err := db.Collection(mongoKeysCollection).Indexes().List(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var indexes []bson.M
+	if err := cursor.All(context.TODO(), &indexes); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	found := false
+	for _, index := range indexes {
+		if strings.HasPrefix(fmt.Sprint(index["name"]), "createdAt") {
+			found = fmt.Sprint(index["expireAfterSeconds"]) == "3600"
+		}
+	}
+	if !found {
+		t.Errorf("unexpected indexes: %v", indexes)
+	}
+}
+
+func prepareMongo(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := NewMongoRepository(newMongoDatabase(t))
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_Mongo(t *testing.T) {
+	testRetryableOncer(t, prepareMongo)
+}
+
+func Test_mongoErrorConvert(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err           error
+		wantDuplicate bool
+	}{
+		"duplicate key": {
+			err:           mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}},
+			wantDuplicate: true,
+		},
+		"other write error": {
+			err: mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 121}}},
+		},
+		"other error": {
+			err: errors.New("error"),
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			err := mongoErrorConvert(tc.err)
+			if duplicated(err) != tc.wantDuplicate {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package atomicop
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/go-sql-driver/mysql"
 )
@@ -41,8 +43,118 @@ func NewMySQLRepository(
 	}
 }
 
-// MySQLRepository implements SyncRepository and StateRepository interface using MySQL
+// MySQLRepository implements SyncRepository and StateRepository interface using MySQL.
+// Use MySQLCASRepository to update state by compare-and-swap.
 type MySQLRepository struct {
 	*SQLRepository
 	*SQLStateRepository
 }
+
+// NewMySQLCASRepository creates an instance for MySQLCASRepository
+func NewMySQLCASRepository(
+	db *sql.DB,
+	builder SQLBuilder,
+	getStateSQLBuilder GetStateSQLBuilder,
+	stateBinder StateBinder,
+	updateStateSQLBuilder UpdateStateSQLBuilder,
+	compareAndSwapStateSQLBuilder CompareAndSwapStateSQLBuilder,
+) *MySQLCASRepository {
+	return &MySQLCASRepository{
+		SQLRepository: NewSQLRepository(
+			db,
+			builder,
+			mysqlErrorConvert,
+		),
+		SQLStateSwapper: NewSQLStateSwapper(
+			db,
+			getStateSQLBuilder,
+			stateBinder,
+			updateStateSQLBuilder,
+			compareAndSwapStateSQLBuilder,
+		),
+	}
+}
+
+// NewDefaultMySQLRepository creates an instance for MySQLCASRepository with the default builders.
+// The table must be created by docker/schema.sql, and db must be opened with parseTime=true.
+// Do not open db with clientFoundRows=true, because the unchanged rows are regarded as swapped.
+func NewDefaultMySQLRepository(db *sql.DB, table string) *MySQLCASRepository {
+	return NewMySQLCASRepository(
+		db,
+		MySQLSQLBuilder(table),
+		MySQLGetStateSQLBuilder(table),
+		MySQLStateBinder,
+		MySQLUpdateStateSQLBuilder(table),
+		MySQLCompareAndSwapStateSQLBuilder(table),
+	)
+}
+
+// MySQLCASRepository implements SyncRepository, StateRepository and StateSwapper interface using MySQL
+type MySQLCASRepository struct {
+	*SQLRepository
+	*SQLStateSwapper
+}
+
+// MySQLSQLBuilder is the default SQLBuilder which inserts key to table.
+func MySQLSQLBuilder(table string) SQLBuilder {
+	q := fmt.Sprintf("INSERT INTO %s(id) VALUES(?)", mysqlQuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// MySQLGetStateSQLBuilder is the default GetStateSQLBuilder which selects state from table.
+func MySQLGetStateSQLBuilder(table string) GetStateSQLBuilder {
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", stateColumns, mysqlQuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// MySQLStateBinder is the default StateBinder for MySQLGetStateSQLBuilder.
+func MySQLStateBinder(rows *sql.Rows, state *State) error {
+	return scanState(rows, state)
+}
+
+// MySQLUpdateStateSQLBuilder is the default UpdateStateSQLBuilder which upserts state to table.
+// The version of state is incremented by every updates.
+func MySQLUpdateStateSQLBuilder(table string) UpdateStateSQLBuilder {
+	q := fmt.Sprintf(`INSERT INTO %s(id, %s) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+		ON DUPLICATE KEY UPDATE
+		state = VALUES(state), attempts = VALUES(attempts), max_attempts = VALUES(max_attempts),
+		deadline = VALUES(deadline), class_attempts = VALUES(class_attempts), retry_at = VALUES(retry_at),
+		owner = VALUES(owner), heartbeat = VALUES(heartbeat), reason = VALUES(reason),
+		version = version + 1`, mysqlQuoteIdentifier(table), stateColumns)
+	return func(key string, state State) (string, []interface{}) {
+		return q, append([]interface{}{key}, stateArgs(state)...)
+	}
+}
+
+// MySQLCompareAndSwapStateSQLBuilder is the default CompareAndSwapStateSQLBuilder.
+// For version zero, state is inserted, or the existing row is updated only if its version is zero.
+// MySQL evaluates the assignments from left to right, so version is assigned at last.
+// Otherwise state is updated with the version condition.
+func MySQLCompareAndSwapStateSQLBuilder(table string) CompareAndSwapStateSQLBuilder {
+	t := mysqlQuoteIdentifier(table)
+	insert := fmt.Sprintf(`INSERT INTO %s(id, %s) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+		ON DUPLICATE KEY UPDATE
+		state = IF(version = 0, VALUES(state), state), attempts = IF(version = 0, VALUES(attempts), attempts),
+		max_attempts = IF(version = 0, VALUES(max_attempts), max_attempts),
+		deadline = IF(version = 0, VALUES(deadline), deadline),
+		class_attempts = IF(version = 0, VALUES(class_attempts), class_attempts),
+		retry_at = IF(version = 0, VALUES(retry_at), retry_at), owner = IF(version = 0, VALUES(owner), owner),
+		heartbeat = IF(version = 0, VALUES(heartbeat), heartbeat), reason = IF(version = 0, VALUES(reason), reason),
+		version = IF(version = 0, 1, version)`, t, stateColumns)
+	update := fmt.Sprintf("UPDATE %s SET %s, version = version + 1 WHERE id = ? AND version = ?",
+		t, stateAssignments(func(int) string { return "?" }))
+	return func(key string, version int64, state State) (string, []interface{}) {
+		if version == 0 {
+			return insert, append([]interface{}{key}, stateArgs(state)...)
+		}
+		return update, append(stateArgs(state), key, version)
+	}
+}
+
+func mysqlQuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
@@ -0,0 +1,107 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// CreateNATSKeyValue creates the JetStream KV bucket used by NATSRepository.
+// Keys and states are deleted by JetStream after ttl from the last update.
+// If ttl is zero, they are kept forever.
+// If the bucket already exists with the same configuration, CreateNATSKeyValue returns it.
+func CreateNATSKeyValue(ctx context.Context, js jetstream.JetStream, bucket string, ttl time.Duration) (jetstream.KeyValue, error) {
+	return js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  bucket,
+		History: 1,
+		TTL:     ttl,
+	})
+}
+
+// NATSRepository implements SyncRepository, StateRepository and StateSwapper interface using NATS JetStream KV.
+// Keys are encoded by base64url because JetStream KV allows only limited characters for keys.
+type NATSRepository struct {
+	kv jetstream.KeyValue
+}
+
+// NewNATSRepository creates a NATSRepository instance
+func NewNATSRepository(kv jetstream.KeyValue) *NATSRepository {
+	return &NATSRepository{kv: kv}
+}
+
+func (r *NATSRepository) key(kind, key string) string {
+	return kind + "/" + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// Store stores key by Create.
+// If key is already exists, this method returns duplicateError
+func (r *NATSRepository) Store(ctx context.Context, key string) error {
+	_, err := r.kv.Create(ctx, r.key("sync", key), []byte{'1'})
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return &duplicateError{err}
+	}
+
+	return err
+}
+
+// GetState gets state.
+// If key is not found, GetState returns initial state.
+func (r *NATSRepository) GetState(ctx context.Context, key string) (*State, error) {
+	entry, err := r.kv.Get(ctx, r.key("state", key))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return &State{Attempts: 0, Value: InitState}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(entry.Value(), &state); err != nil {
+		return nil, err
+	}
+	state.Version = int64(entry.Revision())
+
+	return &state, nil
+}
+
+// UpdateState updates state by Put.
+func (r *NATSRepository) UpdateState(ctx context.Context, key string, state State) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.kv.Put(ctx, r.key("state", key), b)
+	return err
+}
+
+// CompareAndSwapState updates state by Update only if the revision of saved state equals version.
+// The version of state is the revision of the entry.
+// For version zero, state is created by Create.
+func (r *NATSRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+
+	var revision uint64
+	if version == 0 {
+		revision, err = r.kv.Create(ctx, r.key("state", key), b)
+	} else {
+		revision, err = r.kv.Update(ctx, r.key("state", key), b, uint64(version))
+	}
+	// the wrong last sequence error
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d", version)}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(revision), nil
+}
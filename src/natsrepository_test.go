@@ -0,0 +1,108 @@
+package atomicop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// newNATSKeyValue runs an embedded nats-server with JetStream and creates a KV bucket.
+func newNATSKeyValue(t *testing.T, ttl time.Duration) jetstream.KeyValue {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	t.Cleanup(s.Shutdown)
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats-server is not ready")
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := CreateNATSKeyValue(context.Background(), js, "atomicop", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kv
+}
+
+func Test_NATSSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, NewNATSRepository(newNATSKeyValue(t, 0)))
+}
+
+func Test_NATSStateRepository(t *testing.T) {
+	t.Parallel()
+
+	testStateRepository(t, NewNATSRepository(newNATSKeyValue(t, 0)))
+}
+
+func Test_NATSRepository_key(t *testing.T) {
+	t.Parallel()
+
+	r := NewNATSRepository(newNATSKeyValue(t, 0))
+	for _, key := range []string{"a key", "a.key", "*", ">", "", "キー"} {
+		if err := r.Store(context.TODO(), key); err != nil {
+			t.Errorf("unexpected error: %q, %s", key, err)
+		}
+		if err := r.Store(context.TODO(), key); !duplicated(err) {
+			t.Errorf("unexpected error: %q, %v", key, err)
+		}
+	}
+}
+
+func Test_NATSRepository_TTL(t *testing.T) {
+	t.Parallel()
+
+	r := NewNATSRepository(newNATSKeyValue(t, time.Second))
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func prepareNATS(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := NewNATSRepository(newNATSKeyValue(t, 0))
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_NATS(t *testing.T) {
+	testRetryableOncer(t, prepareNATS)
+}
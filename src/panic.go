@@ -0,0 +1,38 @@
+package atomicop
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is an error converted from a panic in function.
+// PanicError has CanRetry() bool method which returns configured value.
+type PanicError struct {
+	Value     interface{}
+	Stack     []byte
+	retryable bool
+}
+
+// CanRetry reports whether the panic is configured as retryable.
+func (err *PanicError) CanRetry() bool {
+	return err.retryable
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", err.Value, err.Stack)
+}
+
+// protect calls fn and converts a panic into PanicError.
+func protect(fn func() error, retryable bool) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{
+				Value:     v,
+				Stack:     debug.Stack(),
+				retryable: retryable,
+			}
+		}
+	}()
+
+	return fn()
+}
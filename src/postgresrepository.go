@@ -0,0 +1,122 @@
+package atomicop
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+func postgresErrorConvert(err error) error {
+	if pqerr, ok := err.(*pq.Error); ok {
+		// unique_violation
+		if pqerr.Code == "23505" {
+			return &duplicateError{pqerr}
+		}
+		return err
+	}
+
+	return err
+}
+
+// NewPostgresRepository creates an instance for PostgresRepository
+func NewPostgresRepository(
+	db *sql.DB,
+	builder SQLBuilder,
+	getStateSQLBuilder GetStateSQLBuilder,
+	stateBinder StateBinder,
+	updateStateSQLBuilder UpdateStateSQLBuilder,
+	compareAndSwapStateSQLBuilder CompareAndSwapStateSQLBuilder,
+) *PostgresRepository {
+	return &PostgresRepository{
+		SQLRepository: NewSQLRepository(
+			db,
+			builder,
+			postgresErrorConvert,
+		),
+		SQLStateSwapper: NewSQLStateSwapper(
+			db,
+			getStateSQLBuilder,
+			stateBinder,
+			updateStateSQLBuilder,
+			compareAndSwapStateSQLBuilder,
+		),
+	}
+}
+
+// NewDefaultPostgresRepository creates an instance for PostgresRepository with the default builders.
+// The table must be created by docker/postgres/schema.sql.
+func NewDefaultPostgresRepository(db *sql.DB, table string) *PostgresRepository {
+	return NewPostgresRepository(
+		db,
+		PostgresSQLBuilder(table),
+		PostgresGetStateSQLBuilder(table),
+		PostgresStateBinder,
+		PostgresUpdateStateSQLBuilder(table),
+		PostgresCompareAndSwapStateSQLBuilder(table),
+	)
+}
+
+// PostgresRepository implements SyncRepository, StateRepository and StateSwapper interface using PostgreSQL
+type PostgresRepository struct {
+	*SQLRepository
+	*SQLStateSwapper
+}
+
+// PostgresSQLBuilder is the default SQLBuilder which inserts key to table.
+func PostgresSQLBuilder(table string) SQLBuilder {
+	q := fmt.Sprintf("INSERT INTO %s(id) VALUES($1)", pq.QuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// PostgresGetStateSQLBuilder is the default GetStateSQLBuilder which selects state from table.
+func PostgresGetStateSQLBuilder(table string) GetStateSQLBuilder {
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE id = $1", stateColumns, pq.QuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// PostgresStateBinder is the default StateBinder for PostgresGetStateSQLBuilder.
+func PostgresStateBinder(rows *sql.Rows, state *State) error {
+	return scanState(rows, state)
+}
+
+// PostgresUpdateStateSQLBuilder is the default UpdateStateSQLBuilder which upserts state to table.
+// The version of state is incremented by every updates.
+func PostgresUpdateStateSQLBuilder(table string) UpdateStateSQLBuilder {
+	t := pq.QuoteIdentifier(table)
+	q := fmt.Sprintf(`INSERT INTO %s(id, %s) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 1)
+		ON CONFLICT (id) DO UPDATE SET
+		state = EXCLUDED.state, attempts = EXCLUDED.attempts, max_attempts = EXCLUDED.max_attempts,
+		deadline = EXCLUDED.deadline, class_attempts = EXCLUDED.class_attempts, retry_at = EXCLUDED.retry_at,
+		owner = EXCLUDED.owner, heartbeat = EXCLUDED.heartbeat, reason = EXCLUDED.reason,
+		version = %s.version + 1, updated_at = CURRENT_TIMESTAMP`, t, stateColumns, t)
+	return func(key string, state State) (string, []interface{}) {
+		return q, append([]interface{}{key}, stateArgs(state)...)
+	}
+}
+
+// PostgresCompareAndSwapStateSQLBuilder is the default CompareAndSwapStateSQLBuilder.
+// For version zero, state is inserted, or the existing row is updated only if its version is zero.
+// Otherwise state is updated with the version condition.
+func PostgresCompareAndSwapStateSQLBuilder(table string) CompareAndSwapStateSQLBuilder {
+	t := pq.QuoteIdentifier(table)
+	insert := fmt.Sprintf(`INSERT INTO %s(id, %s) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 1)
+		ON CONFLICT (id) DO UPDATE SET
+		state = EXCLUDED.state, attempts = EXCLUDED.attempts, max_attempts = EXCLUDED.max_attempts,
+		deadline = EXCLUDED.deadline, class_attempts = EXCLUDED.class_attempts, retry_at = EXCLUDED.retry_at,
+		owner = EXCLUDED.owner, heartbeat = EXCLUDED.heartbeat, reason = EXCLUDED.reason,
+		version = 1, updated_at = CURRENT_TIMESTAMP
+		WHERE %s.version = 0`, t, stateColumns, t)
+	update := fmt.Sprintf(`UPDATE %s SET %s, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND version = $11`, t, stateAssignments(func(i int) string { return fmt.Sprintf("$%d", i+1) }))
+	return func(key string, version int64, state State) (string, []interface{}) {
+		if version == 0 {
+			return insert, append([]interface{}{key}, stateArgs(state)...)
+		}
+		return update, append(append([]interface{}{key}, stateArgs(state)...), version)
+	}
+}
@@ -0,0 +1,163 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// updateStateScript saves state and increments its version.
+// KEYS[1] is the state key, ARGV[1] is the encoded state and ARGV[2] is the ttl in milliseconds.
+var updateStateScript = redis.NewScript(`
+local version = redis.call('HINCRBY', KEYS[1], 'version', 1)
+redis.call('HSET', KEYS[1], 'state', ARGV[1])
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return version
+`)
+
+// compareAndSwapStateScript saves state only if the version equals ARGV[3].
+// It returns -1 if the version is mismatched.
+var compareAndSwapStateScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if current ~= tonumber(ARGV[3]) then
+	return -1
+end
+local version = redis.call('HINCRBY', KEYS[1], 'version', 1)
+redis.call('HSET', KEYS[1], 'state', ARGV[1])
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return version
+`)
+
+// RedisRepository implements SyncRepository, StateRepository and StateSwapper interface using Redis.
+// Each command and script accesses a single key, so that it works on Redis Cluster.
+type RedisRepository struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// RedisRepositoryOption is an option for RedisRepository
+type RedisRepositoryOption func(r *RedisRepository)
+
+// WithRedisKeyPrefix sets the prefix of keys saved to Redis.
+func WithRedisKeyPrefix(prefix string) RedisRepositoryOption {
+	return func(r *RedisRepository) {
+		r.prefix = prefix
+	}
+}
+
+// WithRedisTTL expires keys and states after ttl from the last update.
+// The expired key is regarded as unknown key.
+func WithRedisTTL(ttl time.Duration) RedisRepositoryOption {
+	return func(r *RedisRepository) {
+		r.ttl = ttl
+	}
+}
+
+// NewRedisRepository creates a RedisRepository instance.
+// client can be *redis.Client, *redis.ClusterClient or *redis.Ring.
+func NewRedisRepository(client redis.UniversalClient, opts ...RedisRepositoryOption) *RedisRepository {
+	r := &RedisRepository{
+		client: client,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *RedisRepository) syncKey(key string) string {
+	return r.prefix + "sync:" + key
+}
+
+func (r *RedisRepository) stateKey(key string) string {
+	return r.prefix + "state:" + key
+}
+
+// Store stores key using SET NX.
+// If key is already exists, this method returns duplicateError
+func (r *RedisRepository) Store(ctx context.Context, key string) error {
+	ok, err := r.client.SetNX(ctx, r.syncKey(key), 1, r.ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &duplicateError{errors.New("duplicated")}
+	}
+
+	return nil
+}
+
+// Exists reports whether key is stored.
+func (r *RedisRepository) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.Exists(ctx, r.syncKey(key)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetState gets state using Redis.
+// If key is not found or expired, GetState returns initial state.
+func (r *RedisRepository) GetState(ctx context.Context, key string) (*State, error) {
+	values, err := r.client.HMGet(ctx, r.stateKey(key), "state", "version").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{Attempts: 0, Value: InitState}
+	encoded, ok := values[0].(string)
+	if !ok {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(encoded), state); err != nil {
+		return nil, err
+	}
+	if v, ok := values[1].(string); ok {
+		if _, err := fmt.Sscan(v, &state.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+// UpdateState updates state using Redis.
+func (r *RedisRepository) UpdateState(ctx context.Context, key string, state State) error {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return updateStateScript.Run(ctx, r.client, []string{r.stateKey(key)}, encoded, r.ttl.Milliseconds()).Err()
+}
+
+// CompareAndSwapState updates state only if the version of saved state equals version.
+func (r *RedisRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+
+	next, err := compareAndSwapStateScript.Run(
+		ctx, r.client, []string{r.stateKey(key)}, encoded, r.ttl.Milliseconds(), version,
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if next < 0 {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d", version)}
+	}
+
+	return next, nil
+}
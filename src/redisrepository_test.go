@@ -0,0 +1,70 @@
+package atomicop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return s, client
+}
+
+func Test_RedisSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	_, client := newRedisClient(t)
+	testSyncRepository(t, NewRedisRepository(client))
+}
+
+func Test_RedisStateRepository(t *testing.T) {
+	t.Parallel()
+
+	_, client := newRedisClient(t)
+	testStateRepository(t, NewRedisRepository(client))
+}
+
+func Test_RedisRepository_WithRedisTTL(t *testing.T) {
+	t.Parallel()
+
+	s, client := newRedisClient(t)
+	r := NewRedisRepository(client, WithRedisKeyPrefix("{app}:"), WithRedisTTL(time.Minute))
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Store(context.TODO(), "key"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !s.Exists("{app}:sync:key") || !s.Exists("{app}:state:key") {
+		t.Errorf("unexpected keys: %v", s.Keys())
+	}
+
+	s.FastForward(time.Minute)
+
+	if err := r.Store(context.TODO(), "key"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if st, _ := r.GetState(context.TODO(), "key"); st.Value != InitState || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func prepareRedis(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	_, client := newRedisClient(t)
+	r := NewRedisRepository(client)
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_Redis(t *testing.T) {
+	testRetryableOncer(t, prepareRedis)
+}
@@ -0,0 +1,337 @@
+package atomicop
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// prepareFunc prepares RetryableOncer on a repository for the behavioral tests.
+type prepareFunc func(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error)
+
+// duplicated reports whether err has Duplicate() bool method which returns true.
+func duplicated(err error) bool {
+	v, ok := err.(interface {
+		Duplicate() bool
+	})
+	return ok && v.Duplicate()
+}
+
+// testSyncRepository tests that Once on r executes function at once.
+// If r implements SyncChecker, Exists is tested as well.
+func testSyncRepository(t *testing.T, r SyncRepository) {
+	tests := map[string]struct {
+		n        int
+		key      string
+		parallel bool
+	}{
+		"sequential": {
+			n:   100,
+			key: "seqKey",
+		},
+		"parallel": {
+			n:        100,
+			key:      "parKey",
+			parallel: true,
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			oncer := NewOnce(r)
+
+			var counter = int32(0)
+			fn := func() error {
+				atomic.AddInt32(&counter, 1)
+				return nil
+			}
+
+			var wg sync.WaitGroup
+			var sem chan struct{}
+			if tc.parallel {
+				sem = make(chan struct{}, tc.n)
+			} else {
+				sem = make(chan struct{}, 1)
+			}
+
+			for i := 0; i < tc.n; i++ {
+				wg.Add(1)
+				go func() {
+					sem <- struct{}{}
+					defer func() {
+						wg.Done()
+						<-sem
+					}()
+					err := oncer.Do(context.TODO(), tc.key, fn)
+					if (err != nil) != false {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}()
+			}
+
+			wg.Wait()
+			close(sem)
+
+			if counter != 1 {
+				t.Errorf("unexpected execution times: %d", counter)
+			}
+
+			if checker, ok := r.(SyncChecker); ok {
+				if exists, err := checker.Exists(context.TODO(), tc.key); err != nil || !exists {
+					t.Errorf("unexpected exists: %v, %v", exists, err)
+				}
+				if exists, err := checker.Exists(context.TODO(), tc.key+"-unknown"); err != nil || exists {
+					t.Errorf("unexpected exists: %v, %v", exists, err)
+				}
+			}
+		})
+	}
+}
+
+// testStateRepository tests that r saves state and changes its version.
+// The version is not necessarily incremented by one such as the revision of etcd.
+// If r implements StateSwapper, the compare and swap is tested as well.
+func testStateRepository(t *testing.T, r StateRepository) {
+	st, err := r.GetState(context.TODO(), "stateKey")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if st.Value != InitState || st.Attempts != 0 || st.Version != 0 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+
+	if err := r.UpdateState(context.TODO(), "stateKey", State{
+		Attempts:      1,
+		Value:         RetryState,
+		ClassAttempts: map[string]int{"timeout": 1},
+		Reason:        "reason",
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	st, err = r.GetState(context.TODO(), "stateKey")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if st.Value != RetryState || st.Attempts != 1 || st.Version == 0 ||
+		st.ClassAttempts["timeout"] != 1 || st.Reason != "reason" {
+		t.Errorf("unexpected state: %+v", st)
+	}
+
+	swapper, ok := r.(StateSwapper)
+	if !ok {
+		return
+	}
+
+	_, err = swapper.CompareAndSwapState(context.TODO(), "stateKey", 0, State{Attempts: 2, Value: DoneState})
+	if !conflicted(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	version, err := swapper.CompareAndSwapState(context.TODO(), "stateKey", st.Version, State{Attempts: 2, Value: DoneState})
+	if err != nil || version == st.Version {
+		t.Fatalf("unexpected result: %d, %v", version, err)
+	}
+
+	st, _ = r.GetState(context.TODO(), "stateKey")
+	if st.Value != DoneState || st.Attempts != 2 || st.Version != version {
+		t.Errorf("unexpected state: %+v", st)
+	}
+
+	version, err = swapper.CompareAndSwapState(context.TODO(), "newStateKey", 0, State{Attempts: 1, Value: RunningState})
+	if err != nil || version == 0 {
+		t.Fatalf("unexpected result: %d, %v", version, err)
+	}
+}
+
+// testRetryableOncer runs all the behavioral tests of RetryableOncer.
+func testRetryableOncer(t *testing.T, prepare prepareFunc) {
+	tests := map[string]func(t *testing.T, prepare prepareFunc){
+		"at_once":              testRetryableOncerAtOnce,
+		"retry_success":        testRetryableOncerRetrySuccess,
+		"retry_failed":         testRetryableOncerRetryFailed,
+		"retry_limit_exceeded": testRetryableOncerRetryLimitExceeded,
+		"WithLimit":            testRetryableOncerWithLimit,
+		"WithErrorClasses":     testRetryableOncerWithErrorClasses,
+		"WithAttemptTimeout":   testRetryableOncerWithAttemptTimeout,
+		"Cancel":               testRetryableOncerCancel,
+	}
+
+	for tn, test := range tests {
+		t.Run(tn, func(t *testing.T) {
+			test(t, prepare)
+		})
+	}
+}
+
+func testRetryableOncerAtOnce(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t)
+	defer closer()
+
+	counter := 0
+	for i := 0; i < 5; i++ {
+		err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			return nil
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	if counter != 1 {
+		t.Errorf("unexpected execution times: %d", counter)
+	}
+}
+
+func testRetryableOncerRetrySuccess(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t)
+	defer closer()
+
+	counter := 0
+	successAt := 3
+	for i := 0; i < 5; i++ {
+		err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			if counter < successAt {
+				return &retryableError{errors.New("retry")}
+			}
+			return nil
+		})
+		if err != nil {
+			if rerr, ok := err.(interface {
+				CanRetry() bool
+			}); ok && rerr.CanRetry() {
+				continue
+			}
+
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if counter != successAt {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+}
+
+func testRetryableOncerRetryFailed(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t)
+	defer closer()
+
+	counter := 0
+	for i := 0; i < 10; i++ {
+		retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			return errors.New("no retry")
+		})
+	}
+
+	if counter != 1 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+}
+
+func testRetryableOncerRetryLimitExceeded(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t)
+	defer closer()
+
+	counter := 0
+	for i := 0; i < 10; i++ {
+		retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			return &retryableError{errors.New("retry")}
+		})
+	}
+
+	if counter != 5 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+}
+
+func testRetryableOncerWithLimit(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t)
+	defer closer()
+
+	counter := 0
+	for i := 0; i < 10; i++ {
+		retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			return &retryableError{errors.New("retry")}
+		}, WithLimit(2), WithDeadline(time.Now().Add(time.Hour)))
+	}
+
+	if counter != 2 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+}
+
+func testRetryableOncerWithErrorClasses(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t, WithErrorClasses(
+		func(error) string { return "timeout" },
+		ErrorClass{Name: "timeout", Limit: 2},
+	))
+	defer closer()
+
+	counter := 0
+	for i := 0; i < 10; i++ {
+		err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+			counter++
+			return &retryableError{errors.New("retry")}
+		})
+		// the key fails at the second attempt, then the error is not returned.
+		if i >= 1 && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	// the limit of the class counts attempts as same as WithLimit.
+	if counter != 2 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+}
+
+func testRetryableOncerWithAttemptTimeout(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t, WithAttemptTimeout(100*time.Millisecond))
+	defer closer()
+
+	counter := 0
+	for i := 0; i < 5; i++ {
+		retryableOncer.DoContext(context.TODO(), "retryableKey", func(ctx context.Context) error {
+			counter++
+			if counter < 3 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		})
+	}
+
+	if counter != 3 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+}
+
+func testRetryableOncerCancel(t *testing.T, prepare prepareFunc) {
+	retryableOncer, closer := prepare(t)
+	defer closer()
+
+	if err := retryableOncer.Cancel(context.TODO(), "retryableKey", "reason"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	counter := 0
+	err := retryableOncer.Do(context.TODO(), "retryableKey", func() error {
+		counter++
+		return nil
+	})
+	if cerr, ok := err.(*ErrCancelled); !ok || cerr.Reason != "reason" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if counter != 0 {
+		t.Errorf("unexpected execution time: %d", counter)
+	}
+}
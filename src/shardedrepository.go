@@ -0,0 +1,283 @@
+package atomicop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+type routingKeyContextKey struct{}
+
+// withRoutingKey sets the key which ShardedRepository routes by instead of the key of the operation.
+// RetryableOncer sets the key of Do to route its attempt keys to the same shard as the key.
+func withRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKeyContextKey{}, key)
+}
+
+func routingKey(ctx context.Context, key string) string {
+	if v, ok := ctx.Value(routingKeyContextKey{}).(string); ok {
+		return v
+	}
+	return key
+}
+
+// Shard is a backend of ShardedRepository.
+// Name identifies the shard on hashing, so it must be stable and unique, and must be same on all processes.
+// Sync or State can be nil if the operation is not used.
+type Shard struct {
+	Name  string
+	Sync  SyncRepository
+	State StateRepository
+}
+
+// ShardedRepository implements SyncRepository, StateRepository and StateSwapper interface
+// over multiple shards by rendezvous hashing.
+// A key and the attempt keys of the key by RetryableOncer are routed to the same shard.
+//
+// To add a shard, follow the procedure below.
+//
+//  1. Call AddShard on all processes. While migrating, Store checks whether the key exists in the previous owner
+//     and stores the key only to the new owner, and GetState falls back to the previous owner if the new owner
+//     has no state. CompareAndSwapState creates state only in the new owner, so the previous owners are never
+//     written. The Sync of the previous owners must implement SyncChecker.
+//  2. Wait until the keys stored before step 1 become unnecessary. That is, until they expire by the TTL of
+//     the backends, or until they are copied from the previous owners to the new owners by the backend tools.
+//  3. Call CompleteMigration on all processes. Then the previous owners are no longer accessed.
+//
+// Do not start step 3 until step 1 is finished on all processes.
+// While step 1 is in progress, a key stored or a state swapped concurrently by the processes before and after
+// AddShard may be executed twice.
+// About 1/(N+1) keys move to the new shard, and the other keys are never moved.
+type ShardedRepository struct {
+	mu       sync.RWMutex
+	shards   []Shard
+	previous []Shard
+}
+
+// NewShardedRepository creates a ShardedRepository instance
+func NewShardedRepository(shards ...Shard) (*ShardedRepository, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+	if err := validateShards(shards); err != nil {
+		return nil, err
+	}
+
+	return &ShardedRepository{shards: append([]Shard(nil), shards...)}, nil
+}
+
+func validateShards(shards []Shard) error {
+	names := map[string]bool{}
+	for _, s := range shards {
+		if s.Name == "" {
+			return errors.New("shard name is empty")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("shard %s is duplicated", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	return nil
+}
+
+// AddShard adds shard and starts the migration.
+// AddShard returns an error if the previous migration is not completed yet,
+// or if the Sync of the current shards does not implement SyncChecker.
+func (r *ShardedRepository) AddShard(shard Shard) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.previous != nil {
+		return errors.New("migration is not completed")
+	}
+	shards := append(append([]Shard(nil), r.shards...), shard)
+	if err := validateShards(shards); err != nil {
+		return err
+	}
+	for _, s := range r.shards {
+		if _, ok := s.Sync.(SyncChecker); s.Sync != nil && !ok {
+			return fmt.Errorf("shard %s does not implement SyncChecker", s.Name)
+		}
+	}
+
+	r.previous = r.shards
+	r.shards = shards
+	return nil
+}
+
+// CompleteMigration completes the migration started by AddShard.
+func (r *ShardedRepository) CompleteMigration() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.previous = nil
+}
+
+// Migrating reports whether the migration is in progress.
+func (r *ShardedRepository) Migrating() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.previous != nil
+}
+
+// route returns the owner of key and the previous owner if it is different from the owner.
+func (r *ShardedRepository) route(ctx context.Context, key string) (Shard, *Shard) {
+	key = routingKey(ctx, key)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	owner := rendezvous(r.shards, key)
+	if r.previous == nil {
+		return owner, nil
+	}
+	if previous := rendezvous(r.previous, key); previous.Name != owner.Name {
+		return owner, &previous
+	}
+	return owner, nil
+}
+
+// rendezvous returns the shard which has the highest score for key.
+func rendezvous(shards []Shard, key string) Shard {
+	var owner Shard
+	var highest uint64
+	for i, s := range shards {
+		h := fnv.New64a()
+		h.Write([]byte(s.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		// fnv is not mixed well for similar inputs
+		score := mix64(h.Sum64())
+		if i == 0 || score > highest {
+			owner, highest = s, score
+		}
+	}
+
+	return owner
+}
+
+// mix64 is the finalizer of splitmix64.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Store stores key to the owner shard.
+// While migrating, key is checked in the previous owner without storing it there.
+// If key is already exists in either shard, this method returns duplicateError
+func (r *ShardedRepository) Store(ctx context.Context, key string) error {
+	owner, previous := r.route(ctx, key)
+	if previous != nil {
+		// the previous owner is never written. then a failure of the owner never loses key.
+		exists, err := previous.Sync.(SyncChecker).Exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return &duplicateError{fmt.Errorf("%s exists in shard %s", key, previous.Name)}
+		}
+	}
+
+	return owner.Sync.Store(ctx, key)
+}
+
+// GetState gets state from the owner shard.
+// While migrating, GetState gets state from the previous owner if the owner does not have state.
+// Then the version of state is negative not to be confused with the versions of the owner.
+func (r *ShardedRepository) GetState(ctx context.Context, key string) (*State, error) {
+	owner, previous := r.route(ctx, key)
+	state, err := owner.State.GetState(ctx, key)
+	if err != nil || previous == nil || stateFound(state) {
+		return state, err
+	}
+
+	seed, err := previous.State.GetState(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !stateFound(seed) {
+		return state, nil
+	}
+	seed.Version = previousVersion(seed.Version)
+	return seed, nil
+}
+
+// previousVersion converts the version of the previous owner into the negative version.
+func previousVersion(version int64) int64 {
+	return -version - 1
+}
+
+// UpdateState updates state in the owner shard.
+func (r *ShardedRepository) UpdateState(ctx context.Context, key string, state State) error {
+	owner, _ := r.route(ctx, key)
+	return owner.State.UpdateState(ctx, key, state)
+}
+
+// CompareAndSwapState updates state in the owner shard only if the version of saved state equals version.
+// While migrating, if the owner does not have state yet, version is checked against the state of the previous owner,
+// which is given by GetState, and state is created in the owner. The previous owner is never written,
+// so the owner is the source of truth.
+// If the shard does not implement StateSwapper, the version is checked by GetState before UpdateState,
+// which is not atomic.
+func (r *ShardedRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	owner, previous := r.route(ctx, key)
+	if previous != nil {
+		current, err := owner.State.GetState(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if !stateFound(current) {
+			seed, err := previous.State.GetState(ctx, key)
+			if err != nil {
+				return 0, err
+			}
+			expected := int64(0)
+			if stateFound(seed) {
+				expected = previousVersion(seed.Version)
+			}
+			if version != expected {
+				return 0, &conflictError{fmt.Errorf("version mismatch: %d != %d", version, expected)}
+			}
+			// the concurrent creators are serialized by the owner
+			return compareAndSwapState(ctx, owner.State, key, 0, state)
+		}
+	}
+
+	return compareAndSwapState(ctx, owner.State, key, version, state)
+}
+
+// stateFound reports whether state is saved in StateRepository.
+func stateFound(state *State) bool {
+	return state.Value != InitState
+}
+
+func compareAndSwapState(ctx context.Context, r StateRepository, key string, version int64, state State) (int64, error) {
+	if swapper, ok := r.(StateSwapper); ok {
+		return swapper.CompareAndSwapState(ctx, key, version, state)
+	}
+
+	current, err := r.GetState(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if current.Version != version {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d != %d", version, current.Version)}
+	}
+	if err := r.UpdateState(ctx, key, state); err != nil {
+		return 0, err
+	}
+	updated, err := r.GetState(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return updated.Version, nil
+}
@@ -0,0 +1,325 @@
+package atomicop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func newShards(names ...string) []Shard {
+	shards := make([]Shard, 0, len(names))
+	for _, name := range names {
+		shards = append(shards, Shard{
+			Name:  name,
+			Sync:  NewSyncMapRepository(),
+			State: NewMemoryStateRepository(),
+		})
+	}
+	return shards
+}
+
+func newShardedRepository(t *testing.T, shards []Shard) *ShardedRepository {
+	r, err := NewShardedRepository(shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func Test_NewShardedRepository(t *testing.T) {
+	tests := map[string]struct {
+		shards  []Shard
+		wantErr bool
+	}{
+		"valid":      {shards: newShards("a", "b")},
+		"no shards":  {wantErr: true},
+		"empty name": {shards: newShards("a", ""), wantErr: true},
+		"duplicated": {shards: newShards("a", "a"), wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewShardedRepository(tt.shards...); (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_ShardedSyncRepository(t *testing.T) {
+	testSyncRepository(t, newShardedRepository(t, newShards("a", "b", "c")))
+}
+
+func Test_ShardedStateRepository(t *testing.T) {
+	testStateRepository(t, newShardedRepository(t, newShards("a", "b", "c")))
+}
+
+func Test_ShardedRepository_distribution(t *testing.T) {
+	shards := newShards("a", "b", "c")
+	r := newShardedRepository(t, shards)
+
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		owner, _ := r.route(context.TODO(), fmt.Sprintf("key%d", i))
+		counts[owner.Name]++
+	}
+	for _, s := range shards {
+		if counts[s.Name] < 800 || counts[s.Name] > 1200 {
+			t.Errorf("unexpected distribution: %v", counts)
+		}
+	}
+
+	if err := r.AddShard(newShards("d")[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner, previous := r.route(context.TODO(), key)
+		if previous == nil {
+			continue
+		}
+		// keys move only to the new shard
+		if owner.Name != "d" {
+			t.Errorf("unexpected owner: %s -> %s", previous.Name, owner.Name)
+		}
+		moved++
+	}
+	if moved < 600 || moved > 900 {
+		t.Errorf("unexpected moved keys: %d", moved)
+	}
+}
+
+func Test_ShardedRepository_AddShard(t *testing.T) {
+	r := newShardedRepository(t, newShards("a", "b"))
+	ctx := context.TODO()
+
+	// the keys before the migration
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		if err := r.Store(ctx, keys[i]); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := r.CompareAndSwapState(ctx, keys[i], 0, State{Attempts: 1, Value: RetryState}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := r.AddShard(newShards("a")[0]); err == nil {
+		t.Errorf("duplicated shard is added")
+	}
+	if err := r.AddShard(newShards("c")[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.AddShard(newShards("d")[0]); err == nil {
+		t.Errorf("shard is added while migrating")
+	}
+	if !r.Migrating() {
+		t.Errorf("not migrating")
+	}
+
+	for _, key := range keys {
+		if err := r.Store(ctx, key); !duplicated(err) {
+			t.Errorf("unexpected error: %s, %v", key, err)
+		}
+		st, err := r.GetState(ctx, key)
+		if err != nil || st.Value != RetryState {
+			t.Fatalf("unexpected state: %s, %+v, %v", key, st, err)
+		}
+		// the state is created in the owner with the version of the previous owner
+		if _, err := r.CompareAndSwapState(ctx, key, st.Version+1, State{Attempts: 2, Value: DoneState}); !conflicted(err) {
+			t.Errorf("unexpected error: %s, %v", key, err)
+		}
+		if _, err := r.CompareAndSwapState(ctx, key, st.Version, State{Attempts: 2, Value: DoneState}); err != nil {
+			t.Errorf("unexpected error: %s, %s", key, err)
+		}
+	}
+
+	r.CompleteMigration()
+	if r.Migrating() {
+		t.Errorf("migrating")
+	}
+
+	for _, key := range keys {
+		if st, err := r.GetState(ctx, key); err != nil || st.Value != DoneState {
+			t.Errorf("unexpected state: %s, %+v, %v", key, st, err)
+		}
+	}
+}
+
+func Test_ShardedRepository_AddShard_without_SyncChecker(t *testing.T) {
+	r := newShardedRepository(t, []Shard{{Name: "a", Sync: &mockSyncRepository{}}})
+	if err := r.AddShard(newShards("b")[0]); err == nil {
+		t.Errorf("shard is added without SyncChecker")
+	}
+}
+
+// movedKeys returns n keys which are moved from shard a to shard b.
+func movedKeys(n int) []string {
+	var keys []string
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if rendezvous([]Shard{{Name: "a"}, {Name: "b"}}, key).Name == "b" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func Test_ShardedRepository_Store_while_migrating(t *testing.T) {
+	r := newShardedRepository(t, newShards("a"))
+	ctx := context.TODO()
+	keys := movedKeys(2)
+	if err := r.Store(ctx, keys[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	backing := NewSyncMapRepository()
+	failed := false
+	if err := r.AddShard(Shard{Name: "b", Sync: &mockSyncRepository{
+		MockStore: func(ctx context.Context, key string) error {
+			if !failed {
+				failed = true
+				return errors.New("failed to store")
+			}
+			return backing.Store(ctx, key)
+		},
+	}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the key stored in the previous owner is duplicated
+	if err := r.Store(ctx, keys[0]); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the failure of the owner does not leave the key in the previous owner
+	if err := r.Store(ctx, keys[1]); err == nil {
+		t.Errorf("unexpected success")
+	}
+	if err := r.Store(ctx, keys[1]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.Store(ctx, keys[1]); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_ShardedRepository_CompareAndSwapState_while_migrating(t *testing.T) {
+	r := newShardedRepository(t, newShards("a"))
+	ctx := context.TODO()
+	key := movedKeys(1)[0]
+	if _, err := r.CompareAndSwapState(ctx, key, 0, State{Attempts: 1, Value: RetryState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	previous := r.shards[0].State
+
+	m := NewMemoryStateRepository()
+	failed := false
+	if err := r.AddShard(Shard{Name: "b", Sync: NewSyncMapRepository(), State: &mockStateRepository{
+		MockGetState: m.GetState,
+		MockUpdateState: func(ctx context.Context, key string, state State) error {
+			if !failed {
+				failed = true
+				return errors.New("failed to update")
+			}
+			return m.UpdateState(ctx, key, state)
+		},
+	}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	st, err := r.GetState(ctx, key)
+	if err != nil || st.Value != RetryState {
+		t.Fatalf("unexpected state: %+v, %v", st, err)
+	}
+
+	// the failure of the owner leaves the previous owner as it is
+	if _, err := r.CompareAndSwapState(ctx, key, st.Version, State{Attempts: 2, Value: RunningState}); err == nil {
+		t.Errorf("unexpected success")
+	}
+	if st, err := r.GetState(ctx, key); err != nil || st.Value != RetryState {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+
+	if _, err := r.CompareAndSwapState(ctx, key, st.Version, State{Attempts: 2, Value: RunningState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the version of the previous owner is never same as the version of the owner
+	if _, err := r.CompareAndSwapState(ctx, key, st.Version, State{Attempts: 2, Value: DoneState}); !conflicted(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if st, err := r.GetState(ctx, key); err != nil || st.Value != RunningState {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+	if st, err := previous.GetState(ctx, key); err != nil || st.Value != RetryState {
+		t.Errorf("unexpected state of the previous owner: %+v, %v", st, err)
+	}
+}
+
+func Test_ShardedRepository_GetState_while_migrating(t *testing.T) {
+	// the state of the shards is not versioned
+	newState := func() StateRepository {
+		m := NewMemoryStateRepository()
+		return &mockStateRepository{
+			MockGetState: func(ctx context.Context, key string) (*State, error) {
+				st, err := m.GetState(ctx, key)
+				if err == nil {
+					st.Version = 0
+				}
+				return st, err
+			},
+			MockUpdateState: m.UpdateState,
+		}
+	}
+	r := newShardedRepository(t, []Shard{{Name: "a", Sync: NewSyncMapRepository(), State: newState()}})
+	ctx := context.TODO()
+
+	key := movedKeys(1)[0]
+	if err := r.UpdateState(ctx, key, State{Attempts: 1, Value: RetryState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.AddShard(Shard{Name: "b", Sync: NewSyncMapRepository(), State: newState()}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if st, err := r.GetState(ctx, key); err != nil || st.Value != RetryState {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+	if err := r.UpdateState(ctx, key, State{Attempts: 2, Value: DoneState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the state of the owner is used even if it is not versioned
+	if st, err := r.GetState(ctx, key); err != nil || st.Value != DoneState {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+}
+
+func Test_ShardedRepository_attempt_key(t *testing.T) {
+	shards := newShards("a", "b", "c", "d")
+	r := newShardedRepository(t, shards)
+	oncer := NewRetryableOncer(5, NewOnce(r), r)
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := oncer.Do(context.TODO(), key, func() error { return nil }); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		owner, _ := r.route(context.TODO(), key)
+		if err := owner.Sync.Store(context.TODO(), key+"-1"); !duplicated(err) {
+			t.Errorf("the attempt key is not in the owner of %s: %v", key, err)
+		}
+	}
+}
+
+func prepareSharded(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := newShardedRepository(t, newShards("a", "b", "c"))
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_ShardedRepository(t *testing.T) {
+	testRetryableOncer(t, prepareSharded)
+}
@@ -0,0 +1,204 @@
+package atomicop
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshotter is implemented by the in-memory repositories which can be saved and restored.
+//
+// The snapshot is JSON lines. The first line is a header, and the following lines are entries.
+//
+//	{"format":"atomicop-snapshot","version":1,"kind":"sync"}
+//	{"key":"key-1"}
+//	{"key":"key-2","expires_at":"2019-04-01T00:00:00Z"}
+//
+// The kind is "sync" for SyncRepository and "state" for StateRepository.
+// The kind "bloom" is the filter of BloomRepository, and its entries are not keys but filters.
+// The entry of "state" has "state" field which is JSON of State.
+// The entry which has "expires_at" field is expired at that time.
+// A snapshot of "sync" can be restored to any in-memory SyncRepository.
+type Snapshotter interface {
+	// Snapshot writes all entries to w.
+	Snapshot(w io.Writer) error
+	// Restore reads entries from r and adds them.
+	Restore(r io.Reader) error
+}
+
+const (
+	snapshotFormat  = "atomicop-snapshot"
+	snapshotVersion = 1
+
+	syncSnapshot  = "sync"
+	stateSnapshot = "state"
+	bloomSnapshot = "bloom"
+)
+
+type snapshotHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type snapshotEntry struct {
+	Key       string     `json:"key"`
+	State     *State     `json:"state,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// newSnapshotWriter creates a snapshotWriter and writes the header.
+func newSnapshotWriter(w io.Writer, kind string) (*snapshotWriter, error) {
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw, enc: json.NewEncoder(bw)}
+	if err := sw.enc.Encode(snapshotHeader{
+		Format:  snapshotFormat,
+		Version: snapshotVersion,
+		Kind:    kind,
+	}); err != nil {
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+func (w *snapshotWriter) write(key string, state *State, expiresAt time.Time) error {
+	e := snapshotEntry{Key: key, State: state}
+	if !expiresAt.IsZero() {
+		e.ExpiresAt = &expiresAt
+	}
+	return w.enc.Encode(e)
+}
+
+func (w *snapshotWriter) flush() error {
+	return w.w.Flush()
+}
+
+// newSnapshotDecoder checks the header and returns the decoder of entries.
+func newSnapshotDecoder(r io.Reader, kind string) (*json.Decoder, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if h.Format != snapshotFormat || h.Version != snapshotVersion || h.Kind != kind {
+		return nil, fmt.Errorf("unsupported snapshot: %s version %d of %s", h.Format, h.Version, h.Kind)
+	}
+
+	return dec, nil
+}
+
+// readSnapshot checks the header and calls fn for each entries.
+func readSnapshot(r io.Reader, kind string, fn func(e snapshotEntry) error) error {
+	dec, err := newSnapshotDecoder(r, kind)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var e snapshotEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid snapshot entry: %v", err)
+		}
+		if kind == stateSnapshot && e.State == nil {
+			return fmt.Errorf("invalid snapshot entry: %s has no state", e.Key)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (e snapshotEntry) expiresAt() time.Time {
+	if e.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return *e.ExpiresAt
+}
+
+// SnapshotFile writes the snapshot of s to path.
+// The snapshot is written to a temporary file, then it is renamed to path atomically.
+// The directory of path is synced after the rename to persist it.
+func SnapshotFile(s Snapshotter, path string) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := s.Snapshot(f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	// sync the directory to persist the rename
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// RestoreFile restores s from the snapshot of path.
+// If path does not exist, RestoreFile does nothing.
+func RestoreFile(s Snapshotter, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.Restore(f)
+}
+
+// AutoSnapshot writes the snapshot of s to path by SnapshotFile every interval until ctx is done.
+// When ctx is done, AutoSnapshot writes the last snapshot and returns.
+// If SnapshotFile is failed, AutoSnapshot returns the error.
+func AutoSnapshot(ctx context.Context, s Snapshotter, path string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return SnapshotFile(s, path)
+		case <-ticker.C:
+			if err := SnapshotFile(s, path); err != nil {
+				return err
+			}
+		}
+	}
+}
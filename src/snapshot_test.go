@@ -0,0 +1,191 @@
+package atomicop
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SyncRepository_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	type snapshotSyncRepository interface {
+		SyncRepository
+		Snapshotter
+	}
+	tests := map[string]struct {
+		src, dst snapshotSyncRepository
+	}{
+		"SyncMapRepository": {
+			src: NewSyncMapRepository(),
+			dst: NewSyncMapRepository(),
+		},
+		"BoundedMemoryRepository": {
+			src: NewBoundedMemoryRepository(WithKeyTTL(time.Hour)),
+			dst: NewBoundedMemoryRepository(WithMaxEntries(2)),
+		},
+		"SyncMapRepository to BoundedMemoryRepository": {
+			src: NewSyncMapRepository(),
+			dst: NewBoundedMemoryRepository(),
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			for _, key := range []string{"a", "b"} {
+				if err := tc.src.Store(context.TODO(), key); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := tc.src.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(buf.String(), `{"format":"atomicop-snapshot","version":1,"kind":"sync"}`+"\n") {
+				t.Errorf("unexpected snapshot: %s", buf.String())
+			}
+			if err := tc.dst.Restore(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, key := range []string{"a", "b"} {
+				if err := tc.dst.Store(context.TODO(), key); err == nil {
+					t.Errorf("unexpected success: %s", key)
+				}
+			}
+		})
+	}
+}
+
+func Test_BoundedMemoryRepository_Restore_expired(t *testing.T) {
+	t.Parallel()
+
+	snapshot := `{"format":"atomicop-snapshot","version":1,"kind":"sync"}
+{"key":"expired","expires_at":"2019-04-01T00:00:00Z"}
+{"key":"alive"}
+`
+	r := NewBoundedMemoryRepository()
+	if err := r.Restore(strings.NewReader(snapshot)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.Store(context.TODO(), "expired"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.Store(context.TODO(), "alive"); err == nil {
+		t.Errorf("unexpected success")
+	}
+}
+
+func Test_MemoryStateRepository_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	src := NewMemoryStateRepository()
+	want := State{
+		Attempts:      2,
+		Value:         RetryState,
+		ClassAttempts: map[string]int{"timeout": 2},
+		RetryAt:       time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	src.UpdateState(context.TODO(), "key", want)
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dst := NewMemoryStateRepository()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	st, _ := dst.GetState(context.TODO(), "key")
+	if st.Attempts != 2 || st.Value != RetryState || st.ClassAttempts["timeout"] != 2 ||
+		!st.RetryAt.Equal(want.RetryAt) || st.Version != 1 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+}
+
+func Test_Restore_unsupported(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		r        Snapshotter
+		snapshot string
+	}{
+		"version": {
+			r:        NewSyncMapRepository(),
+			snapshot: `{"format":"atomicop-snapshot","version":2,"kind":"sync"}`,
+		},
+		"kind": {
+			r:        NewMemoryStateRepository(),
+			snapshot: `{"format":"atomicop-snapshot","version":1,"kind":"sync"}`,
+		},
+		"entry": {
+			r:        NewMemoryStateRepository(),
+			snapshot: `{"format":"atomicop-snapshot","version":1,"kind":"state"}` + "\n" + `{"key":"a"}`,
+		},
+		"empty": {
+			r: NewBoundedMemoryRepository(),
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			if err := tc.r.Restore(strings.NewReader(tc.snapshot)); err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
+
+func Test_AutoSnapshot(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "atomicop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.jsonl")
+
+	r := NewSyncMapRepository()
+	r.Store(context.TODO(), "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- AutoSnapshot(ctx, r, path, 10*time.Millisecond)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	r.Store(context.TODO(), "b")
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := NewSyncMapRepository()
+	if err := RestoreFile(restored, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := restored.Store(context.TODO(), key); err == nil {
+			t.Errorf("unexpected success: %s", key)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("unexpected files: %d", len(files))
+	}
+
+	if err := RestoreFile(NewSyncMapRepository(), filepath.Join(dir, "not found")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
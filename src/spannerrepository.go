@@ -0,0 +1,160 @@
+package atomicop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
+)
+
+// spannerStateColumns are the columns of state in the table of docker/spanner/schema.sql.
+var spannerStateColumns = []string{
+	"state", "attempts", "max_attempts", "deadline", "class_attempts",
+	"retry_at", "owner", "heartbeat", "reason", "version",
+}
+
+// SpannerRepository implements SyncRepository, StateRepository and StateSwapper interface using Cloud Spanner.
+// Keys and states are saved to the table created by docker/spanner/schema.sql.
+// A stored key has no state until the state is updated.
+type SpannerRepository struct {
+	client *spanner.Client
+	table  string
+}
+
+// NewSpannerRepository creates a SpannerRepository instance
+func NewSpannerRepository(client *spanner.Client, table string) *SpannerRepository {
+	return &SpannerRepository{
+		client: client,
+		table:  table,
+	}
+}
+
+// Store stores key by an insert mutation.
+// If key is already exists, this method returns duplicateError
+func (r *SpannerRepository) Store(ctx context.Context, key string) error {
+	_, err := r.client.Apply(ctx, []*spanner.Mutation{
+		spanner.Insert(r.table, []string{"id", "created_at"}, []interface{}{key, spanner.CommitTimestamp}),
+	})
+	if spanner.ErrCode(err) == codes.AlreadyExists {
+		return &duplicateError{err}
+	}
+
+	return err
+}
+
+// GetState gets state by a strong read.
+// If key is not found, GetState returns initial state.
+func (r *SpannerRepository) GetState(ctx context.Context, key string) (*State, error) {
+	row, err := r.client.Single().ReadRow(ctx, r.table, spanner.Key{key}, spannerStateColumns)
+	return r.decode(row, err)
+}
+
+func (r *SpannerRepository) decode(row *spanner.Row, err error) (*State, error) {
+	state := &State{Attempts: 0, Value: InitState}
+	if spanner.ErrCode(err) == codes.NotFound {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var value, attempts, limit, version spanner.NullInt64
+	var deadline, retryAt, heartbeat spanner.NullTime
+	var classAttempts, owner, reason spanner.NullString
+	if err := row.Columns(
+		&value, &attempts, &limit, &deadline, &classAttempts,
+		&retryAt, &owner, &heartbeat, &reason, &version,
+	); err != nil {
+		return nil, err
+	}
+	// the key is stored but its state is not updated yet
+	if !value.Valid {
+		return state, nil
+	}
+
+	state.Value = StateValue(value.Int64)
+	state.Attempts = int(attempts.Int64)
+	state.Limit = int(limit.Int64)
+	state.Deadline = deadline.Time
+	state.RetryAt = retryAt.Time
+	state.Owner = owner.StringVal
+	state.Heartbeat = heartbeat.Time
+	state.Reason = reason.StringVal
+	state.Version = version.Int64
+	if classAttempts.Valid {
+		if err := json.Unmarshal([]byte(classAttempts.StringVal), &state.ClassAttempts); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+// UpdateState updates state in a read-write transaction.
+func (r *SpannerRepository) UpdateState(ctx context.Context, key string, state State) error {
+	_, err := r.swap(ctx, key, func(current int64) error { return nil }, state)
+	return err
+}
+
+// CompareAndSwapState updates state only if the version of saved state equals version.
+func (r *SpannerRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	return r.swap(ctx, key, func(current int64) error {
+		if current != version {
+			return &conflictError{fmt.Errorf("version mismatch: %d != %d", version, current)}
+		}
+		return nil
+	}, state)
+}
+
+// swap saves state with the next version in a read-write transaction if check is passed.
+func (r *SpannerRepository) swap(ctx context.Context, key string, check func(current int64) error, state State) (int64, error) {
+	var version int64
+	_, err := r.client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		current, err := r.decode(tx.ReadRow(ctx, r.table, spanner.Key{key}, spannerStateColumns))
+		if err != nil {
+			return err
+		}
+		if err := check(current.Version); err != nil {
+			return err
+		}
+
+		version = current.Version + 1
+		nullTime := func(t time.Time) spanner.NullTime {
+			return spanner.NullTime{Time: t, Valid: !t.IsZero()}
+		}
+		var classAttempts spanner.NullString
+		if len(state.ClassAttempts) > 0 {
+			b, err := json.Marshal(state.ClassAttempts)
+			if err != nil {
+				return err
+			}
+			classAttempts = spanner.NullString{StringVal: string(b), Valid: true}
+		}
+
+		return tx.BufferWrite([]*spanner.Mutation{
+			spanner.InsertOrUpdate(
+				r.table,
+				append([]string{"id", "updated_at"}, spannerStateColumns...),
+				[]interface{}{
+					key, spanner.CommitTimestamp,
+					int64(state.Value), int64(state.Attempts), int64(state.Limit), nullTime(state.Deadline), classAttempts,
+					nullTime(state.RetryAt), state.Owner, nullTime(state.Heartbeat), state.Reason, version,
+				},
+			),
+		})
+	})
+	if err != nil {
+		// the error of check is wrapped by the client
+		var cerr *conflictError
+		if errors.As(err, &cerr) {
+			return 0, cerr
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
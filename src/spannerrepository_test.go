@@ -0,0 +1,119 @@
+package atomicop
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	spannerEmulatorHost = "127.0.0.1:9010"
+	spannerInstance     = "projects/atomicop/instances/atomicop"
+)
+
+var spannerDatabases int32
+
+// newSpannerClient creates a database by docker/spanner/schema.sql on the Spanner emulator.
+func newSpannerClient(t *testing.T) *spanner.Client {
+	if os.Getenv("SPANNER_EMULATOR_HOST") == "" {
+		os.Setenv("SPANNER_EMULATOR_HOST", spannerEmulatorHost)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	instanceAdmin, err := instance.NewInstanceAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instanceAdmin.Close()
+
+	op, err := instanceAdmin.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     "projects/atomicop",
+		InstanceId: "atomicop",
+		Instance: &instancepb.Instance{
+			Config:      "projects/atomicop/instanceConfigs/emulator-config",
+			DisplayName: "atomicop",
+			NodeCount:   1,
+		},
+	})
+	if err == nil {
+		_, err = op.Wait(ctx)
+	}
+	if err != nil && spanner.ErrCode(err) != codes.AlreadyExists {
+		t.Fatalf("failed to create instance: %s", err)
+	}
+
+	ddl, err := os.ReadFile("../docker/spanner/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	databaseAdmin, err := database.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer databaseAdmin.Close()
+
+	name := fmt.Sprintf("test%d-%d", atomic.AddInt32(&spannerDatabases, 1), time.Now().Unix()%100000)
+	dop, err := databaseAdmin.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          spannerInstance,
+		CreateStatement: "CREATE DATABASE `" + name + "`",
+		ExtraStatements: []string{strings.TrimSpace(string(ddl))},
+	})
+	if err == nil {
+		_, err = dop.Wait(ctx)
+	}
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+
+	client, err := spanner.NewClient(context.Background(), spannerInstance+"/databases/"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+func Test_SpannerSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	testSyncRepository(t, NewSpannerRepository(newSpannerClient(t), "atomicop"))
+}
+
+func Test_SpannerStateRepository(t *testing.T) {
+	t.Parallel()
+
+	r := NewSpannerRepository(newSpannerClient(t), "atomicop")
+	testStateRepository(t, r)
+
+	// the stored key has no state
+	if err := r.Store(context.TODO(), "storedKey"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if st, err := r.GetState(context.TODO(), "storedKey"); err != nil || st.Value != InitState {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+}
+
+func prepareSpanner(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := NewSpannerRepository(newSpannerClient(t), "atomicop")
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_Spanner(t *testing.T) {
+	testRetryableOncer(t, prepareSpanner)
+}
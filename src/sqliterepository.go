@@ -0,0 +1,201 @@
+//go:build cgo
+
+package atomicop
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteBusyTimeout is the milliseconds to wait for the lock of other connections or processes.
+const sqliteBusyTimeout = 5000
+
+func sqliteErrorConvert(err error) error {
+	if sqerr, ok := err.(sqlite3.Error); ok {
+		switch {
+		// unique or primary key constraint
+		case sqerr.ExtendedCode == sqlite3.ErrConstraintUnique,
+			sqerr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey:
+			return &duplicateError{sqerr}
+		// the database is locked by other connections or processes even if busy timeout is elapsed
+		case sqerr.Code == sqlite3.ErrBusy, sqerr.Code == sqlite3.ErrLocked:
+			return &retryableError{sqerr}
+		}
+		return err
+	}
+
+	return err
+}
+
+// SQLiteDSN returns the data source name for the database file on path.
+// Transactions of the connection begin with an immediate lock and wait for the busy timeout,
+// so that concurrent writers of other processes are serialized instead of failing with SQLITE_BUSY
+// on the lock upgrade. The journal mode is WAL to allow readers while writing.
+// Note that the immediate lock is taken by any transaction, so SQLiteRepository reads without transaction.
+func SQLiteDSN(path string) string {
+	v := url.Values{}
+	v.Set("_busy_timeout", fmt.Sprint(sqliteBusyTimeout))
+	v.Set("_txlock", "immediate")
+	v.Set("_journal_mode", "WAL")
+	return "file:" + path + "?" + v.Encode()
+}
+
+// CreateSQLiteTable creates the table for the default builders if not exists.
+func CreateSQLiteTable(ctx context.Context, db *sql.DB, table string) error {
+	q := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id             TEXT      NOT NULL PRIMARY KEY
+		, state          INTEGER   NOT NULL DEFAULT 0
+		, attempts       INTEGER   NOT NULL DEFAULT 0
+		, max_attempts   INTEGER   NOT NULL DEFAULT 0
+		, deadline       TIMESTAMP NULL
+		, class_attempts TEXT      NULL
+		, retry_at       TIMESTAMP NULL
+		, owner          TEXT      NOT NULL DEFAULT ''
+		, heartbeat      TIMESTAMP NULL
+		, reason         TEXT      NOT NULL DEFAULT ''
+		, version        INTEGER   NOT NULL DEFAULT 0
+		, created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		, updated_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, sqliteQuoteIdentifier(table))
+	_, err := db.ExecContext(ctx, q)
+	return err
+}
+
+// NewSQLiteRepository creates an instance for SQLiteRepository
+func NewSQLiteRepository(
+	db *sql.DB,
+	builder SQLBuilder,
+	getStateSQLBuilder GetStateSQLBuilder,
+	stateBinder StateBinder,
+	updateStateSQLBuilder UpdateStateSQLBuilder,
+	compareAndSwapStateSQLBuilder CompareAndSwapStateSQLBuilder,
+) *SQLiteRepository {
+	return &SQLiteRepository{
+		SQLRepository: NewSQLRepository(
+			db,
+			builder,
+			sqliteErrorConvert,
+		),
+		SQLStateSwapper: NewSQLStateSwapper(
+			db,
+			getStateSQLBuilder,
+			stateBinder,
+			updateStateSQLBuilder,
+			compareAndSwapStateSQLBuilder,
+		),
+	}
+}
+
+// NewDefaultSQLiteRepository creates an instance for SQLiteRepository with the default builders.
+// The table must be created by CreateSQLiteTable.
+func NewDefaultSQLiteRepository(db *sql.DB, table string) *SQLiteRepository {
+	return NewSQLiteRepository(
+		db,
+		SQLiteSQLBuilder(table),
+		SQLiteGetStateSQLBuilder(table),
+		SQLiteStateBinder,
+		SQLiteUpdateStateSQLBuilder(table),
+		SQLiteCompareAndSwapStateSQLBuilder(table),
+	)
+}
+
+// SQLiteRepository implements SyncRepository, StateRepository and StateSwapper interface using SQLite.
+// db should be opened with SQLiteDSN for the access from multiple processes.
+type SQLiteRepository struct {
+	*SQLRepository
+	*SQLStateSwapper
+}
+
+// GetState gets state using SQLite.
+// The single query is run without transaction, so that it reads the snapshot of WAL without the write lock.
+// If the database is locked, GetState returns retryable error.
+func (r *SQLiteRepository) GetState(ctx context.Context, key string) (*State, error) {
+	state, err := r.getState(ctx, r.SQLStateRepository.db, key)
+	if err != nil {
+		return nil, sqliteErrorConvert(err)
+	}
+	return state, nil
+}
+
+// UpdateState updates state using SQLite.
+// If the database is locked, UpdateState returns retryable error.
+func (r *SQLiteRepository) UpdateState(ctx context.Context, key string, state State) error {
+	return sqliteErrorConvert(r.SQLStateRepository.UpdateState(ctx, key, state))
+}
+
+// CompareAndSwapState updates state using SQLite only if the version of saved state equals version.
+// If the database is locked, CompareAndSwapState returns retryable error.
+func (r *SQLiteRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	next, err := r.SQLStateSwapper.CompareAndSwapState(ctx, key, version, state)
+	if err != nil {
+		return 0, sqliteErrorConvert(err)
+	}
+	return next, nil
+}
+
+// SQLiteSQLBuilder is the default SQLBuilder which inserts key to table.
+func SQLiteSQLBuilder(table string) SQLBuilder {
+	q := fmt.Sprintf("INSERT INTO %s(id) VALUES(?)", sqliteQuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// SQLiteGetStateSQLBuilder is the default GetStateSQLBuilder which selects state from table.
+func SQLiteGetStateSQLBuilder(table string) GetStateSQLBuilder {
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", stateColumns, sqliteQuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// SQLiteStateBinder is the default StateBinder for SQLiteGetStateSQLBuilder.
+func SQLiteStateBinder(rows *sql.Rows, state *State) error {
+	return scanState(rows, state)
+}
+
+// SQLiteUpdateStateSQLBuilder is the default UpdateStateSQLBuilder which upserts state to table.
+// The version of state is incremented by every updates.
+func SQLiteUpdateStateSQLBuilder(table string) UpdateStateSQLBuilder {
+	t := sqliteQuoteIdentifier(table)
+	q := fmt.Sprintf(`INSERT INTO %s(id, %s) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT (id) DO UPDATE SET
+		state = excluded.state, attempts = excluded.attempts, max_attempts = excluded.max_attempts,
+		deadline = excluded.deadline, class_attempts = excluded.class_attempts, retry_at = excluded.retry_at,
+		owner = excluded.owner, heartbeat = excluded.heartbeat, reason = excluded.reason,
+		version = %s.version + 1, updated_at = CURRENT_TIMESTAMP`, t, stateColumns, t)
+	return func(key string, state State) (string, []interface{}) {
+		return q, append([]interface{}{key}, stateArgs(state)...)
+	}
+}
+
+// SQLiteCompareAndSwapStateSQLBuilder is the default CompareAndSwapStateSQLBuilder.
+// For version zero, state is inserted, or the existing row is updated only if its version is zero.
+// Otherwise state is updated with the version condition.
+func SQLiteCompareAndSwapStateSQLBuilder(table string) CompareAndSwapStateSQLBuilder {
+	t := sqliteQuoteIdentifier(table)
+	insert := fmt.Sprintf(`INSERT INTO %s(id, %s) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT (id) DO UPDATE SET
+		state = excluded.state, attempts = excluded.attempts, max_attempts = excluded.max_attempts,
+		deadline = excluded.deadline, class_attempts = excluded.class_attempts, retry_at = excluded.retry_at,
+		owner = excluded.owner, heartbeat = excluded.heartbeat, reason = excluded.reason,
+		version = 1, updated_at = CURRENT_TIMESTAMP
+		WHERE %s.version = 0`, t, stateColumns, t)
+	update := fmt.Sprintf(`UPDATE %s SET %s, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND version = ?`, t, stateAssignments(func(int) string { return "?" }))
+	return func(key string, version int64, state State) (string, []interface{}) {
+		if version == 0 {
+			return insert, append([]interface{}{key}, stateArgs(state)...)
+		}
+		return update, append(stateArgs(state), key, version)
+	}
+}
+
+func sqliteQuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,187 @@
+//go:build cgo
+
+package atomicop
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(t *testing.T, path string) *sql.DB {
+	db, err := sql.Open("sqlite3", SQLiteDSN(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateSQLiteTable(context.TODO(), db, "atomicop"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func Test_SQLiteSyncRepository(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLite(t, filepath.Join(t.TempDir(), "atomicop.db"))
+	defer db.Close()
+
+	testSyncRepository(t, NewDefaultSQLiteRepository(db, "atomicop"))
+}
+
+func Test_SQLiteStateRepository(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLite(t, filepath.Join(t.TempDir(), "atomicop.db"))
+	defer db.Close()
+
+	r := NewDefaultSQLiteRepository(db, "atomicop")
+	testStateRepository(t, r)
+
+	// the row of the stored key has no state
+	if err := r.Store(context.TODO(), "storedKey"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	version, err := r.CompareAndSwapState(context.TODO(), "storedKey", 0, State{Attempts: 1, Value: RunningState})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := r.CompareAndSwapState(context.TODO(), "storedKey", 0, State{Attempts: 1, Value: RunningState}); !conflicted(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if st, err := r.GetState(context.TODO(), "storedKey"); err != nil || st.Value != RunningState || st.Version != version {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+}
+
+func Test_SQLiteSyncRepository_multiple_processes(t *testing.T) {
+	t.Parallel()
+
+	// each DB has its own connections and locks like other processes.
+	path := filepath.Join(t.TempDir(), "atomicop.db")
+	var oncers []*Once
+	for i := 0; i < 3; i++ {
+		db := openSQLite(t, path)
+		defer db.Close()
+		oncers = append(oncers, NewOnce(NewDefaultSQLiteRepository(db, "atomicop")))
+	}
+
+	var counter = int32(0)
+	fn := func() error {
+		atomic.AddInt32(&counter, 1)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(oncer *Once) {
+			defer wg.Done()
+			if err := oncer.Do(context.TODO(), "key", fn); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(oncers[i%len(oncers)])
+	}
+	wg.Wait()
+
+	if counter != 1 {
+		t.Errorf("unexpected count: %d", counter)
+	}
+}
+
+func Test_SQLiteStateRepository_GetState_while_writing(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "atomicop.db")
+	db := openSQLite(t, path)
+	defer db.Close()
+	r := NewDefaultSQLiteRepository(db, "atomicop")
+	if err := r.UpdateState(context.TODO(), "key", State{Attempts: 1, Value: RetryState}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// another process holds the write lock
+	writer := openSQLite(t, path)
+	defer writer.Close()
+	tx, err := writer.BeginTx(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(context.TODO(), "UPDATE atomicop SET state = ? WHERE id = ?", DoneState, "key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the reader does not wait for the busy timeout
+	start := time.Now()
+	st, err := r.GetState(context.TODO(), "key")
+	if err != nil || st.Value != RetryState {
+		t.Errorf("unexpected state: %+v, %v", st, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("unexpected wait: %s", elapsed)
+	}
+}
+
+func prepareSQLite(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "atomicop.db"))
+	r := NewDefaultSQLiteRepository(db, "atomicop")
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), db.Close
+}
+
+func Test_RetryableOncer_with_SQLite(t *testing.T) {
+	testRetryableOncer(t, prepareSQLite)
+}
+
+func Test_sqliteErrorConvert(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err           error
+		wantDuplicate bool
+		wantRetry     bool
+	}{
+		"unique constraint": {
+			err:           sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique},
+			wantDuplicate: true,
+		},
+		"primary key constraint": {
+			err:           sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey},
+			wantDuplicate: true,
+		},
+		"not null constraint": {
+			err: sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull},
+		},
+		"busy": {
+			err:       sqlite3.Error{Code: sqlite3.ErrBusy},
+			wantRetry: true,
+		},
+		"other error": {
+			err: errors.New("error"),
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			err := sqliteErrorConvert(tc.err)
+			v, ok := err.(interface {
+				Duplicate() bool
+			})
+			if (ok && v.Duplicate()) != tc.wantDuplicate {
+				t.Errorf("unexpected error: %v", err)
+			}
+			r, ok := err.(interface {
+				CanRetry() bool
+			})
+			if (ok && r.CanRetry()) != tc.wantRetry {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package atomicop
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func sqlserverErrorConvert(err error) error {
+	if mserr, ok := err.(mssql.Error); ok {
+		// violation of primary key or unique constraint, or duplicate key row of unique index
+		if mserr.Number == 2627 || mserr.Number == 2601 {
+			return &duplicateError{mserr}
+		}
+		return err
+	}
+
+	return err
+}
+
+// NewSQLServerRepository creates an instance for SQLServerRepository
+func NewSQLServerRepository(
+	db *sql.DB,
+	builder SQLBuilder,
+	getStateSQLBuilder GetStateSQLBuilder,
+	stateBinder StateBinder,
+	updateStateSQLBuilder UpdateStateSQLBuilder,
+	compareAndSwapStateSQLBuilder CompareAndSwapStateSQLBuilder,
+) *SQLServerRepository {
+	return &SQLServerRepository{
+		SQLRepository: NewSQLRepository(
+			db,
+			builder,
+			sqlserverErrorConvert,
+		),
+		SQLStateSwapper: NewSQLStateSwapper(
+			db,
+			getStateSQLBuilder,
+			stateBinder,
+			updateStateSQLBuilder,
+			compareAndSwapStateSQLBuilder,
+		),
+	}
+}
+
+// NewDefaultSQLServerRepository creates an instance for SQLServerRepository with the default builders.
+// The table must be created by docker/sqlserver/schema.sql.
+func NewDefaultSQLServerRepository(db *sql.DB, table string) *SQLServerRepository {
+	return NewSQLServerRepository(
+		db,
+		SQLServerSQLBuilder(table),
+		SQLServerGetStateSQLBuilder(table),
+		SQLServerStateBinder,
+		SQLServerUpdateStateSQLBuilder(table),
+		SQLServerCompareAndSwapStateSQLBuilder(table),
+	)
+}
+
+// SQLServerRepository implements SyncRepository, StateRepository and StateSwapper interface using SQL Server
+type SQLServerRepository struct {
+	*SQLRepository
+	*SQLStateSwapper
+}
+
+// SQLServerSQLBuilder is the default SQLBuilder which inserts key to table.
+func SQLServerSQLBuilder(table string) SQLBuilder {
+	q := fmt.Sprintf("INSERT INTO %s(id) VALUES(@p1)", sqlserverQuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// SQLServerGetStateSQLBuilder is the default GetStateSQLBuilder which selects state from table.
+func SQLServerGetStateSQLBuilder(table string) GetStateSQLBuilder {
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE id = @p1", stateColumns, sqlserverQuoteIdentifier(table))
+	return func(key string) (string, []interface{}) {
+		return q, []interface{}{key}
+	}
+}
+
+// SQLServerStateBinder is the default StateBinder for SQLServerGetStateSQLBuilder.
+func SQLServerStateBinder(rows *sql.Rows, state *State) error {
+	return scanState(rows, state)
+}
+
+// SQLServerUpdateStateSQLBuilder is the default UpdateStateSQLBuilder which upserts state to table by MERGE.
+// The table is locked by HOLDLOCK not to insert the same key concurrently.
+// The version of state is incremented by every updates.
+func SQLServerUpdateStateSQLBuilder(table string) UpdateStateSQLBuilder {
+	q := fmt.Sprintf(`MERGE INTO %s WITH (HOLDLOCK) AS t
+		USING (SELECT @p1 AS id) AS s ON t.id = s.id
+		WHEN MATCHED THEN UPDATE SET
+		state = @p2, attempts = @p3, max_attempts = @p4, deadline = @p5, class_attempts = @p6,
+		retry_at = @p7, owner = @p8, heartbeat = @p9, reason = @p10,
+		version = t.version + 1, updated_at = SYSUTCDATETIME()
+		WHEN NOT MATCHED THEN INSERT (id, %s)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, 1);`, sqlserverQuoteIdentifier(table), stateColumns)
+	return func(key string, state State) (string, []interface{}) {
+		return q, append([]interface{}{key}, stateArgs(state)...)
+	}
+}
+
+// SQLServerCompareAndSwapStateSQLBuilder is the default CompareAndSwapStateSQLBuilder which swaps state by MERGE.
+// The existing row is updated only if its version equals version,
+// and the row is inserted only if it is not found and version is zero.
+func SQLServerCompareAndSwapStateSQLBuilder(table string) CompareAndSwapStateSQLBuilder {
+	q := fmt.Sprintf(`MERGE INTO %s WITH (HOLDLOCK) AS t
+		USING (SELECT @p1 AS id) AS s ON t.id = s.id
+		WHEN MATCHED AND t.version = @p11 THEN UPDATE SET
+		state = @p2, attempts = @p3, max_attempts = @p4, deadline = @p5, class_attempts = @p6,
+		retry_at = @p7, owner = @p8, heartbeat = @p9, reason = @p10,
+		version = t.version + 1, updated_at = SYSUTCDATETIME()
+		WHEN NOT MATCHED AND @p11 = 0 THEN INSERT (id, %s)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, 1);`, sqlserverQuoteIdentifier(table), stateColumns)
+	return func(key string, version int64, state State) (string, []interface{}) {
+		return q, append(append([]interface{}{key}, stateArgs(state)...), version)
+	}
+}
+
+func sqlserverQuoteIdentifier(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
@@ -3,7 +3,10 @@ package atomicop
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // NewSQLStateRepository creates a SQLStateRepository instance.
@@ -27,6 +30,11 @@ type GetStateSQLBuilder func(key string) (query string, args []interface{})
 // UpdateStateSQLBuilder is an interface for building SQL query
 type UpdateStateSQLBuilder func(key string, state State) (query string, args []interface{})
 
+// CompareAndSwapStateSQLBuilder is an interface for building SQL query.
+// The query must save state with the next version only if the saved version equals version,
+// and must affect no rows otherwise. The version of unknown key is zero.
+type CompareAndSwapStateSQLBuilder func(key string, version int64, state State) (query string, args []interface{})
+
 // StateBinder binds state from rows
 type StateBinder func(rows *sql.Rows, state *State) error
 
@@ -38,6 +46,8 @@ type SQLStateRepository struct {
 	updateStateSQLBuilder UpdateStateSQLBuilder
 }
 
+// GetState gets state using SQL DB.
+// If key is not found, GetState returns initial state.
 func (r *SQLStateRepository) GetState(ctx context.Context, key string) (state *State, err error) {
 	opts := &sql.TxOptions{ReadOnly: true}
 	tx, err := r.db.BeginTx(ctx, opts)
@@ -50,26 +60,48 @@ func (r *SQLStateRepository) GetState(ctx context.Context, key string) (state *S
 		}
 	}()
 
-	q, args := r.getStateSQLBuilder(key)
-	rows, err := tx.QueryContext(ctx, q, args...)
-	defer rows.Close()
-
+	state, err = r.getState(ctx, tx, key)
 	if err != nil {
 		return nil, err
 	}
-	if !rows.Next() {
-		return &State{0, InitState}, nil
+
+	// the transaction is read only. it is finished by commit.
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// getState gets state by the query on q, which is a transaction or DB.
+func (r *SQLStateRepository) getState(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}, key string) (*State, error) {
+	query, args := r.getStateSQLBuilder(key)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	state = new(State)
-	if err := r.stateBinder(rows, state); err != nil {
+	state := &State{Attempts: 0, Value: InitState}
+	if rows.Next() {
+		if err := r.stateBinder(rows, state); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := rows.Close(); err != nil {
 		return nil, err
 	}
 
 	return state, nil
 }
 
-func (r *SQLStateRepository) UpdateState(ctx context.Context, key string, state State) error {
+// UpdateState updates state using SQL DB.
+func (r *SQLStateRepository) UpdateState(ctx context.Context, key string, state State) (err error) {
 	opts := &sql.TxOptions{ReadOnly: false}
 	tx, err := r.db.BeginTx(ctx, opts)
 	if err != nil {
@@ -100,3 +132,112 @@ func (r *SQLStateRepository) UpdateState(ctx context.Context, key string, state
 
 	return nil
 }
+
+// NewSQLStateSwapper creates a SQLStateSwapper instance.
+func NewSQLStateSwapper(
+	db *sql.DB,
+	getStateSQLBuilder GetStateSQLBuilder,
+	stateBinder StateBinder,
+	updateStateSQLBuilder UpdateStateSQLBuilder,
+	compareAndSwapStateSQLBuilder CompareAndSwapStateSQLBuilder,
+) *SQLStateSwapper {
+	return &SQLStateSwapper{
+		SQLStateRepository:            NewSQLStateRepository(db, getStateSQLBuilder, stateBinder, updateStateSQLBuilder),
+		compareAndSwapStateSQLBuilder: compareAndSwapStateSQLBuilder,
+	}
+}
+
+// SQLStateSwapper implements StateRepository and StateSwapper interface using SQL DB
+type SQLStateSwapper struct {
+	*SQLStateRepository
+	compareAndSwapStateSQLBuilder CompareAndSwapStateSQLBuilder
+}
+
+// CompareAndSwapState updates state using SQL DB only if the version of saved state equals version.
+// If no rows are affected, the version is regarded as mismatched.
+func (r *SQLStateSwapper) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (_ int64, err error) {
+	opts := &sql.TxOptions{ReadOnly: false}
+	tx, err := r.db.BeginTx(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	q, args := r.compareAndSwapStateSQLBuilder(key, version, state)
+	result, err := tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, &conflictError{fmt.Errorf("version mismatch: %d", version)}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return version + 1, nil
+}
+
+// stateColumns are the columns of state which are used by the default SQL builders.
+// The columns are defined by the schema files in docker directory.
+const stateColumns = "state, attempts, max_attempts, deadline, class_attempts, retry_at, owner, heartbeat, reason, version"
+
+// stateAssignments are the assignments of stateColumns except version for UPDATE statement.
+// placeholder returns the placeholder of the i-th argument of stateArgs from 1.
+func stateAssignments(placeholder func(i int) string) string {
+	columns := []string{
+		"state", "attempts", "max_attempts", "deadline", "class_attempts",
+		"retry_at", "owner", "heartbeat", "reason",
+	}
+	assignments := make([]string, len(columns))
+	for i, c := range columns {
+		assignments[i] = c + " = " + placeholder(i+1)
+	}
+	return strings.Join(assignments, ", ")
+}
+
+// stateArgs returns the arguments of stateColumns except version.
+func stateArgs(state State) []interface{} {
+	nullTime := func(t time.Time) sql.NullTime {
+		return sql.NullTime{Time: t, Valid: !t.IsZero()}
+	}
+	var classAttempts sql.NullString
+	if len(state.ClassAttempts) > 0 {
+		b, _ := json.Marshal(state.ClassAttempts)
+		classAttempts = sql.NullString{String: string(b), Valid: true}
+	}
+
+	return []interface{}{
+		state.Value, state.Attempts, state.Limit, nullTime(state.Deadline), classAttempts,
+		nullTime(state.RetryAt), state.Owner, nullTime(state.Heartbeat), state.Reason,
+	}
+}
+
+// scanState scans stateColumns to state.
+func scanState(rows *sql.Rows, state *State) error {
+	var deadline, retryAt, heartbeat sql.NullTime
+	var classAttempts sql.NullString
+	if err := rows.Scan(
+		&state.Value, &state.Attempts, &state.Limit, &deadline, &classAttempts,
+		&retryAt, &state.Owner, &heartbeat, &state.Reason, &state.Version,
+	); err != nil {
+		return err
+	}
+	state.Deadline = deadline.Time
+	state.RetryAt = retryAt.Time
+	state.Heartbeat = heartbeat.Time
+	if classAttempts.Valid {
+		return json.Unmarshal([]byte(classAttempts.String), &state.ClassAttempts)
+	}
+
+	return nil
+}
@@ -3,7 +3,9 @@ package atomicop
 import (
 	"context"
 	"errors"
+	"io"
 	"sync"
+	"time"
 )
 
 // SyncMapRepository implements SyncRepository interface using sync.Map
@@ -13,8 +15,7 @@ type SyncMapRepository struct {
 
 // NewSyncMapRepository creates a SyncMapRepository instance
 func NewSyncMapRepository() *SyncMapRepository {
-	var m sync.Map
-	return &SyncMapRepository{m: m}
+	return &SyncMapRepository{}
 }
 
 // Store stores key.
@@ -27,3 +28,40 @@ func (r *SyncMapRepository) Store(ctx context.Context, key string) error {
 
 	return nil
 }
+
+// Exists reports whether key is stored.
+func (r *SyncMapRepository) Exists(ctx context.Context, key string) (bool, error) {
+	_, ok := r.m.Load(key)
+	return ok, nil
+}
+
+// Snapshot writes all keys to w.
+func (r *SyncMapRepository) Snapshot(w io.Writer) error {
+	sw, err := newSnapshotWriter(w, syncSnapshot)
+	if err != nil {
+		return err
+	}
+
+	r.m.Range(func(key, _ interface{}) bool {
+		err = sw.write(key.(string), nil, time.Time{})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sw.flush()
+}
+
+// Restore adds keys from the snapshot of rd.
+// The keys which are already expired are skipped, and the others are never expired.
+func (r *SyncMapRepository) Restore(rd io.Reader) error {
+	now := time.Now()
+	return readSnapshot(rd, syncSnapshot, func(e snapshotEntry) error {
+		if expiresAt := e.expiresAt(); !expiresAt.IsZero() && !now.Before(expiresAt) {
+			return nil
+		}
+		r.m.Store(e.Key, struct{}{})
+		return nil
+	})
+}
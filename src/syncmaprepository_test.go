@@ -25,7 +25,8 @@ func Test_SyncMapRepository(t *testing.T) {
 
 	for tn, tc := range tests {
 		t.Run(tn, func(t *testing.T) {
-			oncer := NewOnce(NewSyncMapRepository())
+			r := NewSyncMapRepository()
+			oncer := NewOnce(r)
 
 			var counter = int32(0)
 			fn := func() error {
@@ -62,6 +63,9 @@ func Test_SyncMapRepository(t *testing.T) {
 			if counter != 1 {
 				t.Errorf("unexpected execution times: %d", counter)
 			}
+			if exists, _ := r.Exists(context.TODO(), "same"); !exists {
+				t.Errorf("key does not exist")
+			}
 		})
 	}
 }
@@ -0,0 +1,203 @@
+package atomicop
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultTieredMaxEntries = 10000
+
+// TieredRepository implements SyncRepository, SyncChecker and StateRepository interface
+// with the local caches in front of the shared repositories.
+// Use TieredSwapRepository if the shared StateRepository implements StateSwapper.
+//
+// Store remembers the keys which are confirmed duplicated by the shared SyncRepository,
+// and returns the same duplicate error for them without the round trip.
+// GetState remembers the terminal states, DoneState and FailedState, which are never changed by RetryableOncer.
+// The other keys and states are always delegated to the shared repositories.
+//
+// Attention: if the keys or states expire in the shared repositories, set TTL shorter than it by WithTieredTTL.
+// Otherwise the expired key is still regarded as duplicated on the process.
+type TieredRepository struct {
+	mu     sync.Mutex
+	sync   SyncRepository
+	state  StateRepository
+	keys   *lru
+	states *lru
+	now    func() time.Time
+
+	keyHits   uint64
+	stateHits uint64
+}
+
+// TieredRepositoryStats is statistics of TieredRepository
+type TieredRepositoryStats struct {
+	// Keys is the number of cached duplicated keys.
+	Keys int
+	// States is the number of cached terminal states.
+	States int
+	// KeyHits is the number of Store answered by the cache.
+	KeyHits uint64
+	// StateHits is the number of GetState answered by the cache.
+	StateHits uint64
+}
+
+// TieredRepositoryOption is an option for TieredRepository
+type TieredRepositoryOption func(r *TieredRepository)
+
+// WithTieredMaxEntries limits the number of keys and states of each cache. The default is 10000.
+func WithTieredMaxEntries(n int) TieredRepositoryOption {
+	return func(r *TieredRepository) {
+		r.keys.maxEntries = n
+		r.states.maxEntries = n
+	}
+}
+
+// WithTieredTTL expires cached keys and states after ttl from cached.
+func WithTieredTTL(ttl time.Duration) TieredRepositoryOption {
+	return func(r *TieredRepository) {
+		r.keys.ttl = ttl
+		r.states.ttl = ttl
+	}
+}
+
+// NewTieredRepository creates a TieredRepository instance.
+// syncRepo or stateRepo can be nil if the operation is not used.
+func NewTieredRepository(syncRepo SyncRepository, stateRepo StateRepository, opts ...TieredRepositoryOption) *TieredRepository {
+	r := &TieredRepository{
+		sync:   syncRepo,
+		state:  stateRepo,
+		keys:   newLRU(defaultTieredMaxEntries, 0, 0),
+		states: newLRU(defaultTieredMaxEntries, 0, 0),
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Store returns the cached duplicate error if key is confirmed duplicated.
+// Otherwise Store stores key to the shared repository.
+// If BloomRepository in front of it has not seen key, the cache is skipped without the lock
+// because the cache knows only the keys stored through it. See NotSeenByFilter.
+func (r *TieredRepository) Store(ctx context.Context, key string) error {
+	if !NotSeenByFilter(ctx) {
+		r.mu.Lock()
+		if v, ok := r.keys.get(key, r.now(), true); ok {
+			r.keyHits++
+			r.mu.Unlock()
+			return v.(error)
+		}
+		r.mu.Unlock()
+	}
+
+	err := r.sync.Store(ctx, key)
+	if v, ok := err.(interface {
+		Duplicate() bool
+	}); ok && v.Duplicate() {
+		r.mu.Lock()
+		r.keys.add(key, err, r.now())
+		r.mu.Unlock()
+	}
+
+	return err
+}
+
+// Exists returns true if key is confirmed duplicated by the cache.
+// Otherwise Exists checks key in the shared repository, which must implement SyncChecker.
+func (r *TieredRepository) Exists(ctx context.Context, key string) (bool, error) {
+	r.mu.Lock()
+	if _, ok := r.keys.get(key, r.now(), true); ok {
+		r.keyHits++
+		r.mu.Unlock()
+		return true, nil
+	}
+	r.mu.Unlock()
+
+	checker, ok := r.sync.(SyncChecker)
+	if !ok {
+		return false, errors.New("shared SyncRepository does not implement SyncChecker")
+	}
+	return checker.Exists(ctx, key)
+}
+
+// GetState returns the cached state if the state of key is terminal.
+// Otherwise GetState gets state from the shared repository.
+func (r *TieredRepository) GetState(ctx context.Context, key string) (*State, error) {
+	r.mu.Lock()
+	if v, ok := r.states.get(key, r.now(), true); ok {
+		r.stateHits++
+		r.mu.Unlock()
+		state := v.(State)
+		return &state, nil
+	}
+	r.mu.Unlock()
+
+	state, err := r.state.GetState(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if state.Value == DoneState || state.Value == FailedState {
+		r.mu.Lock()
+		r.states.add(key, *state, r.now())
+		r.mu.Unlock()
+	}
+
+	return state, nil
+}
+
+// invalidate deletes the cached state of key.
+func (r *TieredRepository) invalidate(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.states.delete(key)
+}
+
+// UpdateState updates state in the shared repository.
+func (r *TieredRepository) UpdateState(ctx context.Context, key string, state State) error {
+	r.invalidate(key)
+	return r.state.UpdateState(ctx, key, state)
+}
+
+// TieredSwapRepository is TieredRepository which implements StateSwapper interface
+// over the shared StateRepository which implements StateSwapper.
+type TieredSwapRepository struct {
+	*TieredRepository
+	swapper StateSwapper
+}
+
+// NewTieredSwapRepository creates a TieredSwapRepository instance.
+// syncRepo can be nil if the operation is not used.
+func NewTieredSwapRepository(syncRepo SyncRepository, stateRepo interface {
+	StateRepository
+	StateSwapper
+}, opts ...TieredRepositoryOption) *TieredSwapRepository {
+	return &TieredSwapRepository{
+		TieredRepository: NewTieredRepository(syncRepo, stateRepo, opts...),
+		swapper:          stateRepo,
+	}
+}
+
+// CompareAndSwapState updates state in the shared repository only if the version of saved state equals version.
+func (r *TieredSwapRepository) CompareAndSwapState(ctx context.Context, key string, version int64, state State) (int64, error) {
+	r.invalidate(key)
+	return r.swapper.CompareAndSwapState(ctx, key, version, state)
+}
+
+// Stats returns statistics.
+func (r *TieredRepository) Stats() TieredRepositoryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return TieredRepositoryStats{
+		Keys:      r.keys.len(),
+		States:    r.states.len(),
+		KeyHits:   r.keyHits,
+		StateHits: r.stateHits,
+	}
+}
@@ -0,0 +1,192 @@
+package atomicop
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRepository counts the calls to the shared repository.
+type countingRepository struct {
+	*SyncMapRepository
+	*MemoryStateRepository
+	stores    int32
+	exists    int32
+	getStates int32
+}
+
+func newCountingRepository() *countingRepository {
+	return &countingRepository{
+		SyncMapRepository:     NewSyncMapRepository(),
+		MemoryStateRepository: NewMemoryStateRepository(),
+	}
+}
+
+func (r *countingRepository) Store(ctx context.Context, key string) error {
+	atomic.AddInt32(&r.stores, 1)
+	return r.SyncMapRepository.Store(ctx, key)
+}
+
+func (r *countingRepository) Exists(ctx context.Context, key string) (bool, error) {
+	atomic.AddInt32(&r.exists, 1)
+	return r.SyncMapRepository.Exists(ctx, key)
+}
+
+func (r *countingRepository) GetState(ctx context.Context, key string) (*State, error) {
+	atomic.AddInt32(&r.getStates, 1)
+	return r.MemoryStateRepository.GetState(ctx, key)
+}
+
+func Test_TieredSyncRepository(t *testing.T) {
+	testSyncRepository(t, NewTieredRepository(NewSyncMapRepository(), nil))
+}
+
+func Test_TieredStateRepository(t *testing.T) {
+	testStateRepository(t, NewTieredRepository(nil, NewMemoryStateRepository()))
+	testStateRepository(t, NewTieredSwapRepository(nil, NewMemoryStateRepository()))
+}
+
+func Test_TieredRepository_StateSwapper(t *testing.T) {
+	var r StateRepository = NewTieredRepository(nil, &mockStateRepository{})
+	if _, ok := r.(StateSwapper); ok {
+		t.Errorf("TieredRepository implements StateSwapper")
+	}
+	r = NewTieredSwapRepository(nil, NewMemoryStateRepository())
+	if _, ok := r.(StateSwapper); !ok {
+		t.Errorf("TieredSwapRepository does not implement StateSwapper")
+	}
+}
+
+func Test_TieredRepository_Exists(t *testing.T) {
+	shared := newCountingRepository()
+	r := NewTieredRepository(shared, shared)
+	ctx := context.TODO()
+
+	r.Store(ctx, "key")
+	if exists, err := r.Exists(ctx, "key"); err != nil || !exists {
+		t.Errorf("unexpected exists: %v, %v", exists, err)
+	}
+	// the duplicated key is answered by the cache
+	r.Store(ctx, "key")
+	if exists, err := r.Exists(ctx, "key"); err != nil || !exists {
+		t.Errorf("unexpected exists: %v, %v", exists, err)
+	}
+	if exists, err := r.Exists(ctx, "unknown"); err != nil || exists {
+		t.Errorf("unexpected exists: %v, %v", exists, err)
+	}
+	if shared.exists != 2 {
+		t.Errorf("unexpected calls: %d", shared.exists)
+	}
+
+	// the tiered shard can be migrated
+	sharded := newShardedRepository(t, []Shard{{Name: "a", Sync: r}})
+	if err := sharded.AddShard(newShards("b")[0]); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	r = NewTieredRepository(&mockSyncRepository{}, nil)
+	if _, err := r.Exists(ctx, "key"); err == nil {
+		t.Errorf("unexpected success")
+	}
+}
+
+func Test_TieredRepository_Store(t *testing.T) {
+	shared := newCountingRepository()
+	r := NewTieredRepository(shared, shared, WithTieredMaxEntries(2), WithTieredTTL(time.Minute))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	ctx := context.TODO()
+
+	if err := r.Store(ctx, "key1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := r.Store(ctx, "key1"); !duplicated(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	// the first key is not cached, and the duplicated key is cached at the first time
+	if shared.stores != 2 {
+		t.Errorf("unexpected stores: %d", shared.stores)
+	}
+	if stats := r.Stats(); stats.Keys != 1 || stats.KeyHits != 9 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// key1 is evicted by key2 and key3
+	for _, key := range []string{"key2", "key3"} {
+		r.Store(ctx, key)
+		r.Store(ctx, key)
+	}
+	if err := r.Store(ctx, "key1"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if shared.stores != 7 {
+		t.Errorf("unexpected stores: %d", shared.stores)
+	}
+
+	// key1 is expired
+	now = now.Add(time.Minute)
+	if err := r.Store(ctx, "key1"); !duplicated(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if shared.stores != 8 {
+		t.Errorf("unexpected stores: %d", shared.stores)
+	}
+}
+
+func Test_TieredRepository_GetState(t *testing.T) {
+	tests := map[string]struct {
+		value  StateValue
+		cached bool
+	}{
+		"done":      {value: DoneState, cached: true},
+		"failed":    {value: FailedState, cached: true},
+		"retry":     {value: RetryState},
+		"running":   {value: RunningState},
+		"cancelled": {value: CancelledState},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			shared := newCountingRepository()
+			r := NewTieredSwapRepository(shared, shared)
+			ctx := context.TODO()
+
+			if err := r.UpdateState(ctx, "key", State{Attempts: 1, Value: tt.value}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for i := 0; i < 3; i++ {
+				st, err := r.GetState(ctx, "key")
+				if err != nil || st.Value != tt.value || st.Version != 1 {
+					t.Errorf("unexpected state: %+v, %v", st, err)
+				}
+			}
+			want := int32(3)
+			if tt.cached {
+				want = 1
+			}
+			if shared.getStates != want {
+				t.Errorf("unexpected calls: %d", shared.getStates)
+			}
+
+			// the update invalidates the cache
+			if _, err := r.CompareAndSwapState(ctx, "key", 1, State{Attempts: 2, Value: RetryState}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if st, _ := r.GetState(ctx, "key"); st.Value != RetryState || st.Version != 2 {
+				t.Errorf("unexpected state: %+v", st)
+			}
+		})
+	}
+}
+
+func prepareTiered(t *testing.T, opts ...RetryableOncerOption) (*RetryableOncer, func() error) {
+	r := NewTieredSwapRepository(NewSyncMapRepository(), NewMemoryStateRepository())
+	return NewRetryableOncer(5, NewOnce(r), r, opts...), func() error { return nil }
+}
+
+func Test_RetryableOncer_with_TieredRepository(t *testing.T) {
+	testRetryableOncer(t, prepareTiered)
+}